@@ -11,20 +11,28 @@ import (
 var addr net.Addr
 
 func TestMain(m *testing.M) {
-	s, err := newServer("dem-files", "/tmp", ":0")
+	s, err := newServer(&loader{demFileDir: "dem-files", mmapFileDir: "/tmp"}, ":0")
 	if err != nil {
 		panic(err)
 	}
 
 	addr = s.Listener.Addr()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
 	go func() {
-		if err = s.Serve(context.Background()); err != nil {
+		if err = s.Serve(ctx); err != nil {
 			panic(err)
 		}
+		close(stopped)
 	}()
 
-	os.Exit(m.Run())
+	code := m.Run()
+
+	// Stop the server, which releases the elevation map
+	cancel()
+	<-stopped
+	os.Exit(code)
 }
 
 func BenchmarkGaldhopiggen(b *testing.B) {
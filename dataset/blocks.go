@@ -0,0 +1,119 @@
+package dataset
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// The maximum elevations form a hierarchy of blocks, so that large areas that are too low to be seen can be skipped.
+// A block is a maplet, a tile or a region of regionTiles x regionTiles tiles in the grid of an ElevationMap. Tiles
+// store the maximum elevation of their maplets and of the whole tile, and the others are computed from them when
+// they are first used. Like for the maplets, the maximum elevation of a block includes the extra row and column of
+// points that is shared with the next block.
+//
+// Only the tile at the position that is looked up is mapped, since it is about to be traced, and the maplets in the
+// blocks of a downsampled level, which are read to trace it. The other tiles in a block are not mapped, since most of
+// them are off the traced terrain. Their maximum elevation is known when they have been mapped, and +Inf before.
+const regionTiles = 4
+
+// BlockSizes is the sizes of the blocks that have a maximum elevation, smallest first. The blocks of each size are
+// aligned with the grid, and the blocks of the smaller sizes.
+var BlockSizes = []IntStep{ElevationMapletSize, bigSquareSize, regionTiles * bigSquareSize}
+
+// blockKey identifies a block in the grid, by the size and the position counted in blocks
+type blockKey struct {
+	size IntStep
+	e    IntStep
+	n    IntStep
+}
+
+// blockMax is the maximum elevation of a block that is kept in the grid. If it is not known, it is only used until
+// the maximum elevation of another tile is known, which is when elevationTiles.knownTiles changes.
+type blockMax struct {
+	max        float64
+	known      bool
+	knownTiles int64
+}
+
+// BlockMaxElevation returns the maximum elevation in the block with the given size from BlockSizes that contains
+// the given easting/northing. It is -1 if there is no elevation data in the block, otherwise at least 0. It is +Inf
+// if the block has tiles that have not been mapped, or tiles in other zones may cover it.
+func (em *ElevationMap) BlockMaxElevation(e IntStep, n IntStep, size IntStep) float64 {
+	if e >= 0 && n >= 0 {
+		factor := em.grid.levelFactor()
+		em.grid.lookupMmapStruct(int(e*factor/bigSquareSize), int(n*factor/bigSquareSize))
+	}
+	max, _ := em.blockMaxElevation(e, n, size)
+	return max
+}
+
+// blockMaxElevation is BlockMaxElevation, and returns false if the maximum elevation is +Inf because of tiles that
+// have not been mapped
+func (em *ElevationMap) blockMaxElevation(e IntStep, n IntStep, size IntStep) (float64, bool) {
+	if e < 0 || n < 0 {
+		return -1, true
+	}
+	if size == ElevationMapletSize && em.grid.level == 0 {
+		return em.MaxElevation(e, n), true
+	}
+
+	key := blockKey{size: size, e: e / size, n: n / size}
+	knownTiles := atomic.LoadInt64(&em.tiles.knownTiles)
+	if b, ok := em.grid.blockMax.Load(key); ok && (b.(blockMax).known || b.(blockMax).knownTiles == knownTiles) {
+		return b.(blockMax).max, b.(blockMax).known
+	}
+
+	var max float64
+	known := true
+	factor := em.grid.levelFactor()
+	switch {
+	case size == bigSquareSize && em.grid.level == 0:
+		max, known = em.grid.tileMaxElevation(int(key.e), int(key.n))
+	case size == ElevationMapletSize || size == bigSquareSize:
+		// Downsampled points at the border are computed from the first points of the next blocks in the tiles
+		tiles := em.Level(0)
+		max, known = tiles.subBlockMaxElevation(key, size, factor, true)
+	default:
+		max, known = em.subBlockMaxElevation(key, bigSquareSize, regionTiles, false)
+	}
+	em.grid.blockMax.Store(key, blockMax{max: max, known: known, knownTiles: knownTiles})
+	return max, known
+}
+
+// subBlockMaxElevation returns the maximum elevation of the count x count blocks with size subSize that make up the
+// given block, and the next row and column of them if extra is set. It returns false if any of them is not known.
+func (em *ElevationMap) subBlockMaxElevation(key blockKey, subSize IntStep, count IntStep, extra bool) (float64, bool) {
+	end := count
+	if extra {
+		end++
+	}
+
+	e0, n0 := key.e*count*subSize, key.n*count*subSize
+	max := -1.0
+	known := true
+	for i := IntStep(0); i < end; i++ {
+		for j := IntStep(0); j < end; j++ {
+			m, ok := em.blockMaxElevation(e0+j*subSize, n0+i*subSize, subSize)
+			max = math.Max(max, m)
+			known = known && ok
+		}
+	}
+	return max, known
+}
+
+// tileMaxElevation returns the maximum elevation of the tile with the given tile indices. If there is no tile, it
+// is -1, or +Inf if tiles in other zones may cover it, since the elevations resampled from them are unknown. The
+// maximum elevation of a tile is known once it has been mapped, otherwise it is +Inf and false is returned.
+func (g *zoneGrid) tileMaxElevation(e int, n int) (float64, bool) {
+	if tile := g.tileAtIndex(e, n); tile != nil {
+		max, ok := tile.maxElevation.Load().(Elevation16)
+		if !ok {
+			return math.Inf(1), false
+		}
+		return float64(max) * Elevation16Unit, true
+	}
+	if g.hasForeignTiles(e, n) {
+		return math.Inf(1), true
+	}
+	return -1, true
+}
@@ -0,0 +1,88 @@
+package dataset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBlockMaxElevation(t *testing.T) {
+	west := tileAt(32, 61, 9)
+	east := west
+	east.MinEasting += west.size()
+	r := testReader{
+		tiles: map[string]TilePosition{
+			"west.dem":    west,
+			"east.dem":    east,
+			"foreign.dem": tileAt(33, 61, 16),
+		},
+		elevation: func(lat, lng float64) float64 {
+			return 1000*(lat-60) + 100*lng
+		},
+	}
+
+	em, report, cleanup := loadTestFiles(t, &r)
+	defer cleanup()
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The maximum elevation of a tile is not known until it is mapped, and only the tile at the position that is
+	// looked up is mapped
+	e := IntStep((east.MinEasting - gridMinEasting) / Unit)
+	n := IntStep((gridMaxNorthing - east.MaxNorthing) / Unit)
+	for _, size := range BlockSizes[1:] {
+		if got, known := em.blockMaxElevation(e, n, size); known || !math.IsInf(got, 1) {
+			t.Errorf("maximum of block with size %d is %v before the tile is mapped", size, got)
+		}
+	}
+	if len(em.tiles.mapped) != 0 {
+		t.Errorf("%d tiles mapped to find the maximum elevations", len(em.tiles.mapped))
+	}
+	em.BlockMaxElevation(e, n, regionTiles*bigSquareSize)
+	if len(em.tiles.mapped) != 1 {
+		t.Errorf("%d tiles mapped to find the maximum elevation of a region", len(em.tiles.mapped))
+	}
+
+	// Each block is at least as high as the maplets in it, and the maximum of a tile is the maximum of its maplets
+	for level := 0; level <= MaxLevel; level++ {
+		m := em.Level(level)
+		minEasting, maxNorthing := m.Offsets()
+		e := IntStep(math.Round((east.MinEasting - minEasting) / m.Spacing()))
+		n := IntStep(math.Round((maxNorthing - east.MaxNorthing) / m.Spacing()))
+
+		tileMax := -1.0
+		for i := IntStep(0); i < bigSquareSize; i += ElevationMapletSize {
+			for j := IntStep(0); j < bigSquareSize; j += ElevationMapletSize {
+				max := m.MaxElevation(e+j, n+i)
+				tileMax = math.Max(tileMax, max)
+				for _, size := range BlockSizes {
+					if block := m.BlockMaxElevation(e+j, n+i, size); block < max {
+						t.Fatalf("maximum %v of block with size %d at level %d is below %v", block, size, level, max)
+					}
+				}
+			}
+		}
+		if level == 0 {
+			if got := m.BlockMaxElevation(e, n, bigSquareSize); got != tileMax {
+				t.Errorf("maximum of tile is %v, want %v", got, tileMax)
+			}
+		}
+	}
+
+	// Blocks without tiles have no data, unless a tile in another zone may cover them
+	utm := NewUTM(32)
+	for _, tc := range []struct {
+		lat, lng float64
+		want     float64
+	}{
+		{65, 9, -1},
+		{61, 16, math.Inf(1)},
+	} {
+		easting, northing := utm.LatLngToUTM(tc.lat, tc.lng)
+		e := IntStep((easting - gridMinEasting) / Unit)
+		n := IntStep((gridMaxNorthing - northing) / Unit)
+		if got := em.BlockMaxElevation(e, n, bigSquareSize); got != tc.want {
+			t.Errorf("maximum of tile at %v, %v is %v, want %v", tc.lat, tc.lng, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package dataset
+
+import (
+	"bytes"
+	"compress/flate"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// Compressed mmap files store each maplet on its own, so that a maplet can be decompressed when it is used.
+// A compressed mmap file is an mmapHeader with compressedMagic, followed by a compressedDirectory, the encoded
+// maplets and the encoded downsampled levels of each maplet. Everything is stored in little endian byte order.
+// A maplet, or the levels of a maplet, is encoded as either
+//   - mapletConstant followed by an Elevation16, if all points have the same elevation, or
+//   - mapletDeflated followed by the deflated differences between neighbour points. The differences are stored
+//     row by row, as all the low bytes followed by all the high bytes, since most of the high bytes are 0 or 0xff.
+const (
+	mapletConstant = 0
+	mapletDeflated = 1
+)
+
+// compressedMagic identifies compressed mmap files
+var compressedMagic = [8]byte{'B', 'L', 'A', 'N', 'E', 'C', 'M', 'P'}
+
+// defaultMapletCacheSize is the number of decompressed maplets kept in memory if LoadOptions doesn't say.
+// A maplet is 80 kB, so this is 80 MB.
+const defaultMapletCacheSize = 1024
+
+const mapletPoints = ElevationMapletSize * ElevationMapletSize
+
+// compressedDirectory is the start of the payload in compressed mmap files
+type compressedDirectory struct {
+	EastingMin    float64
+	NorthingMax   float64
+	Zone          int64
+	Spacing       float64
+	MaxElevation  Elevation16
+	MaxElevations [numberOfElevationMaplets][numberOfElevationMaplets]Elevation16
+
+	// Offsets is the offset in the payload of each maplet, row by row, and then of the levels of each maplet,
+	// followed by the size of the payload
+	Offsets [2*numberOfElevationMaplets*numberOfElevationMaplets + 1]uint64
+}
+
+var compressedDirectorySize = binary.Size(compressedDirectory{})
+
+// compressedTile is a tile that is mapped into memory from a compressed mmap file
+type compressedTile struct {
+	compressedDirectory
+
+	// data is the mapped memory, including the header
+	data []byte
+
+	cache *mapletCache
+}
+
+func (t *compressedTile) position() TilePosition {
+	return TilePosition{
+		Zone:        int(t.Zone),
+		MinEasting:  t.EastingMin,
+		MaxNorthing: t.NorthingMax,
+		Spacing:     t.Spacing,
+	}
+}
+
+func (t *compressedTile) maxElevation(i int, j int) Elevation16 {
+	return t.MaxElevations[i][j]
+}
+
+func (t *compressedTile) tileMaxElevation() Elevation16 {
+	return t.MaxElevation
+}
+
+// maplet returns the maplet from the cache, and decompresses it if needed
+func (t *compressedTile) maplet(i int, j int) *ElevationMaplet {
+	k := i*numberOfElevationMaplets + j
+	data := t.data[mmapHeaderSize+t.Offsets[k] : mmapHeaderSize+t.Offsets[k+1]]
+
+	// Maplets with the same constant elevation are shared by all tiles
+	key := mapletKey{tile: t, i: i, j: j}
+	if len(data) == 3 && data[0] == mapletConstant {
+		key = mapletKey{i: int(int16(binary.LittleEndian.Uint16(data[1:])))}
+	}
+
+	if maplet := t.cache.get(key); maplet != nil {
+		return maplet
+	}
+
+	var maplet ElevationMaplet
+	if err := decodePoints(data, maplet.points(), ElevationMapletSize); err != nil {
+		log.Printf("failed to decompress maplet %d, %d in tile %v: %v", i, j, t.position(), err)
+		return nil
+	}
+	return t.cache.add(key, &maplet)
+}
+
+// levels decompresses the downsampled levels of the maplet. They are not cached, since the maplets assembled from
+// them are.
+func (t *compressedTile) levels(i int, j int) *mapletLevels {
+	k := numberOfElevationMaplets*numberOfElevationMaplets + i*numberOfElevationMaplets + j
+	var levels mapletLevels
+	if err := decodePoints(t.data[mmapHeaderSize+t.Offsets[k]:mmapHeaderSize+t.Offsets[k+1]], levels[:], levelSizes...); err != nil {
+		log.Printf("failed to decompress levels of maplet %d, %d in tile %v: %v", i, j, t.position(), err)
+		return nil
+	}
+	return &levels
+}
+
+// Close unmaps the tile. The tile must not be used afterwards. Maplets that are already decompressed can still be
+// used.
+func (t *compressedTile) Close() error {
+	return syscall.Munmap(t.data)
+}
+
+// compressTile returns the payload of a compressed mmap file for the given mmap5000
+func compressTile(m *mmap5000) ([]byte, error) {
+	directory := compressedDirectory{
+		EastingMin:    m.EastingMin,
+		NorthingMax:   m.NorthingMax,
+		Zone:          m.Zone,
+		Spacing:       m.Spacing,
+		MaxElevation:  m.MaxElevation,
+		MaxElevations: m.MaxElevations,
+	}
+
+	var maplets bytes.Buffer
+	k := 0
+	for i := 0; i < numberOfElevationMaplets; i++ {
+		for j := 0; j < numberOfElevationMaplets; j++ {
+			directory.Offsets[k] = uint64(compressedDirectorySize + maplets.Len())
+			if err := encodePoints(&maplets, m.Elevations[i][j].points(), ElevationMapletSize); err != nil {
+				return nil, err
+			}
+			k++
+		}
+	}
+	for i := 0; i < numberOfElevationMaplets; i++ {
+		for j := 0; j < numberOfElevationMaplets; j++ {
+			directory.Offsets[k] = uint64(compressedDirectorySize + maplets.Len())
+			if err := encodePoints(&maplets, m.Levels[i][j][:], levelSizes...); err != nil {
+				return nil, err
+			}
+			k++
+		}
+	}
+	directory.Offsets[k] = uint64(compressedDirectorySize + maplets.Len())
+
+	var payload bytes.Buffer
+	if err := binary.Write(&payload, binary.LittleEndian, &directory); err != nil {
+		return nil, err
+	}
+	payload.Write(maplets.Bytes())
+	return payload.Bytes(), nil
+}
+
+// encodePoints writes the encoded points to buf. The points are one or more squares with the given sizes, stored row
+// by row after each other.
+func encodePoints(buf *bytes.Buffer, points []Elevation16, sizes ...int) error {
+	constant := true
+	for _, elevation := range points {
+		constant = constant && elevation == points[0]
+	}
+	if constant {
+		buf.WriteByte(mapletConstant)
+		return binary.Write(buf, binary.LittleEndian, points[0])
+	}
+
+	// The difference is from the point to the left, or the point above for the first column
+	deltas := make([]byte, 2*len(points))
+	offset := 0
+	for _, size := range sizes {
+		for m := 0; m < size; m++ {
+			for n := 0; n < size; n++ {
+				k := offset + m*size + n
+				var previous Elevation16
+				if n > 0 {
+					previous = points[k-1]
+				} else if m > 0 {
+					previous = points[k-size]
+				}
+				delta := uint16(points[k] - previous)
+				deltas[k] = byte(delta)
+				deltas[len(points)+k] = byte(delta >> 8)
+			}
+		}
+		offset += size * size
+	}
+
+	buf.WriteByte(mapletDeflated)
+	w, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(deltas); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// decodePoints decodes points written by encodePoints with the same sizes
+func decodePoints(data []byte, points []Elevation16, sizes ...int) error {
+	if len(data) == 0 {
+		return errors.New("empty maplet")
+	}
+
+	switch data[0] {
+	case mapletConstant:
+		if len(data) != 3 {
+			return fmt.Errorf("constant maplet of %d bytes", len(data))
+		}
+		elevation := Elevation16(binary.LittleEndian.Uint16(data[1:]))
+		for k := range points {
+			points[k] = elevation
+		}
+
+	case mapletDeflated:
+		deltas := make([]byte, 2*len(points))
+		if _, err := io.ReadFull(flate.NewReader(bytes.NewReader(data[1:])), deltas); err != nil {
+			return err
+		}
+		offset := 0
+		for _, size := range sizes {
+			for m := 0; m < size; m++ {
+				for n := 0; n < size; n++ {
+					k := offset + m*size + n
+					var previous Elevation16
+					if n > 0 {
+						previous = points[k-1]
+					} else if m > 0 {
+						previous = points[k-size]
+					}
+					delta := uint16(deltas[k]) | uint16(deltas[len(points)+k])<<8
+					points[k] = previous + Elevation16(delta)
+				}
+			}
+			offset += size * size
+		}
+
+	default:
+		return fmt.Errorf("unknown maplet encoding %d", data[0])
+	}
+	return nil
+}
+
+// setCompressedPayload changes the header to describe the given compressed payload
+func (h *mmapHeader) setCompressedPayload(payload []byte) {
+	h.Magic = compressedMagic
+	h.PayloadSize = uint64(len(payload))
+	h.PayloadChecksum = crc32.ChecksumIEEE(payload)
+}
+
+// openCompressed maps the compressed mmap file with the given header. Maplets are decompressed into cache.
+func openCompressed(fname string, header *mmapHeader, verify bool, cache *mapletCache) (*compressedTile, error) {
+	file, err := os.OpenFile(fname, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, mmapHeaderSize+int(header.PayloadSize), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &compressedTile{data: data, cache: cache}
+	if err = t.readDirectory(header, verify); err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+	return t, nil
+}
+
+// readDirectory reads and checks the compressedDirectory in the mapped data
+func (t *compressedTile) readDirectory(header *mmapHeader, verify bool) error {
+	payload := t.data[mmapHeaderSize:]
+	if verify && crc32.ChecksumIEEE(payload) != header.PayloadChecksum {
+		return errors.New("checksum mismatch")
+	}
+	if err := binary.Read(bytes.NewReader(payload), binary.LittleEndian, &t.compressedDirectory); err != nil {
+		return err
+	}
+	if header.CRS != crsName(int(t.Zone)) {
+		return fmt.Errorf("unexpected zone %d for %s", t.Zone, string(bytes.TrimRight(header.CRS[:], "\x00")))
+	}
+	if header.Unit != t.Spacing {
+		return fmt.Errorf("unexpected spacing %v, want %v", t.Spacing, header.Unit)
+	}
+
+	// Maplets must be within the payload, since they are accessed without further checks
+	previous := uint64(compressedDirectorySize)
+	for _, offset := range t.Offsets {
+		if offset < previous {
+			return fmt.Errorf("invalid maplet offset %d", offset)
+		}
+		previous = offset
+	}
+	if previous != header.PayloadSize {
+		return fmt.Errorf("maplets end at %d, want %d", previous, header.PayloadSize)
+	}
+	return nil
+}
+
+// mapletKey identifies a maplet in a mapletCache. It is either a maplet in a tile, or a maplet in the grid of a
+// downsampled level or resampled from other zones. Maplets with a constant elevation have neither, and the
+// elevation in i.
+type mapletKey struct {
+	tile *compressedTile
+	grid *zoneGrid
+	i    int
+	j    int
+}
+
+// mapletCache holds the most recently used decompressed maplets from all compressed tiles in an ElevationMap, and
+// the most recently used maplets assembled from downsampled levels or resampled from other zones.
+// Maplets are allocated on the heap, so they stay valid after they are removed from the cache.
+type mapletCache struct {
+	size int
+
+	mutex   sync.Mutex
+	maplets map[mapletKey]*list.Element
+
+	// lru contains *cachedMaplet with the most recently used first
+	lru *list.List
+}
+
+type cachedMaplet struct {
+	key    mapletKey
+	maplet *ElevationMaplet
+}
+
+// newMapletCache returns a mapletCache that holds up to size maplets
+func newMapletCache(size int) *mapletCache {
+	return &mapletCache{
+		size:    size,
+		maplets: map[mapletKey]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// get returns the maplet with the given key, or nil if it is not in the cache
+func (c *mapletCache) get(key mapletKey) *ElevationMaplet {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.maplets[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*cachedMaplet).maplet
+}
+
+// add adds the maplet with the given key, and removes the least recently used maplet if the cache is full.
+// It returns the maplet that is already in the cache if another goroutine added it first.
+func (c *mapletCache) add(key mapletKey, maplet *ElevationMaplet) *ElevationMaplet {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.maplets[key]; ok {
+		c.lru.MoveToFront(element)
+		return element.Value.(*cachedMaplet).maplet
+	}
+
+	c.maplets[key] = c.lru.PushFront(&cachedMaplet{key: key, maplet: maplet})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Remove(c.lru.Back()).(*cachedMaplet)
+		delete(c.maplets, oldest.key)
+	}
+	return maplet
+}
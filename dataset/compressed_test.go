@@ -0,0 +1,76 @@
+package dataset
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressedTile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compressed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fName := filepath.Join(dir, "source.dem")
+	if err = ioutil.WriteFile(fName, []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The western half is flat, and the eastern half has slopes in both directions
+	tile := TilePosition{Zone: 33, MinEasting: 100_000, MaxNorthing: 7_000_000, Spacing: Unit}
+	buffer := make([][]float32, bigSquareSize+1)
+	for i := range buffer {
+		buffer[i] = make([]float32, bigSquareSize+1)
+		for j := range buffer[i] {
+			if j >= bigSquareSize/2 {
+				buffer[i][j] = float32((i*7+j*3)%2000) - 100.5
+			}
+		}
+	}
+	read := func() ([][]float32, TilePosition, error) {
+		return buffer, tile, nil
+	}
+
+	format := tileFormat{compress: true, cache: newMapletCache(2), verify: true}
+	m, err := loadFileAsMmap(fName, nil, fName+format.extension(), format, read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	want := toMmapStruct(buffer)
+	for i := 0; i < numberOfElevationMaplets; i++ {
+		for j := 0; j < numberOfElevationMaplets; j++ {
+			if got := m.maplet(i, j); got == nil || *got != want.Elevations[i][j] {
+				t.Fatalf("maplet %d, %d differs", i, j)
+			}
+			if got := m.levels(i, j); got == nil || *got != want.Levels[i][j] {
+				t.Fatalf("levels of maplet %d, %d differ", i, j)
+			}
+			if got := m.maxElevation(i, j); got != want.MaxElevations[i][j] {
+				t.Fatalf("max elevation in maplet %d, %d is %d, want %d", i, j, got, want.MaxElevations[i][j])
+			}
+		}
+	}
+
+	if m.position() != tile {
+		t.Errorf("position is %v, want %v", m.position(), tile)
+	}
+	if n := format.cache.lru.Len(); n != 2 {
+		t.Errorf("%d maplets in the cache, want 2", n)
+	}
+	if m.maplet(0, 0) != m.maplet(1, 1) {
+		t.Errorf("flat maplets are not shared")
+	}
+
+	fileInfo, err := os.Stat(fName + format.extension())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileInfo.Size() > int64(mmapStructSize)/4 {
+		t.Errorf("compressed file is %d bytes", fileInfo.Size())
+	}
+}
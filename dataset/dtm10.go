@@ -0,0 +1,86 @@
+package dataset
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// UTM has functions to translate between easting/northing in a UTM zone and lat/lng
+type UTM struct {
+	zone       int
+	projection transverseMercator
+}
+
+// NewUTM returns a UTM for the given zone
+func NewUTM(zone int) UTM {
+	return UTM{
+		zone:       zone,
+		projection: newTransverseMercator(zoneCentralMeridian(zone)),
+	}
+}
+
+// Datasets for the UTM zones used by Kartverket
+var (
+	DTM10UTM32Dataset = NewUTM(32)
+	DTM10UTM33Dataset = NewUTM(33)
+	DTM10UTM35Dataset = NewUTM(35)
+)
+
+// Zone returns the UTM zone number
+func (dtm *UTM) Zone() int {
+	return dtm.zone
+}
+
+// LatLngToUTM translates lat/lng to easting/northing
+func (dtm *UTM) LatLngToUTM(lat float64, lng float64) (easting float64, northing float64) {
+	return dtm.projection.forward(lat, lng)
+}
+
+// UTMToLatLng translates easting/northing to lat/lng
+func (dtm *UTM) UTMToLatLng(easting float64, northing float64) (lat float64, lng float64) {
+	return dtm.projection.inverse(easting, northing)
+}
+
+// ReadFile reads the given USGS DEM file and returns the contents. The file may be in any UTM zone, which is
+// returned as part of the tile position.
+func (dtm *UTM) ReadFile(fname string) (buffer [][]float32, tile TilePosition, err error) {
+	log.Printf("reading %s", fname)
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, tile, err
+	}
+	defer file.Close()
+
+	dem, err := readUSGSDEM(file)
+	if err != nil {
+		return nil, tile, fmt.Errorf("failed to read dem file: %w", err)
+	}
+
+	if err = checkSpacing(dem.resolution); err != nil {
+		return nil, tile, err
+	}
+
+	buffer, tile.MinEasting, tile.MaxNorthing, err = dem.alignedBuffer(readerAlignment, dem.resolution, bigSquareSize+1)
+	if err != nil {
+		return nil, tile, fmt.Errorf("failed to read dem file: %w", err)
+	}
+	tile.Zone = dem.zone
+	tile.Spacing = dem.resolution
+	return buffer, tile, nil
+}
+
+// Locate returns the position of the tile in the given USGS DEM file, using only the header
+func (dtm *UTM) Locate(fname string) (TilePosition, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return TilePosition{}, err
+	}
+	defer file.Close()
+
+	dem, err := readUSGSDEMHeader(file)
+	if err != nil {
+		return TilePosition{}, fmt.Errorf("failed to read dem file: %w", err)
+	}
+	return dem.tilePosition(), nil
+}
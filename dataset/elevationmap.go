@@ -4,113 +4,606 @@
 package dataset
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
 )
 
 const (
-	// Unit is the number of meters between each elevation point in the grid of elevation points
+	// Unit is the default number of meters between each elevation point in the grid of elevation points.
+	// Tiles may have other spacings, see TilePosition.
 	Unit = 10
 
 	// ElevationMapletSize is the dimension of an ElevationMaplet
 	ElevationMapletSize      = 200
 	bigSquareSize            = 5000
 	numberOfElevationMaplets = bigSquareSize / ElevationMapletSize
+
+	// tileSize is the size in meters of a tile with the default spacing
+	tileSize = bigSquareSize * Unit
+
+	// gridMinEasting and gridMaxNorthing is the origin of the grid in all zones. The origin is fixed, so that a
+	// tile has the same tile coordinates in every ElevationMap. It is far enough to the west and north to give
+	// positive indices for the tiles in a zone and its neighbour zones, and aligned with tiles of all spacings.
+	gridMinEasting  = -5_000_000
+	gridMaxNorthing = 10_000_000
+
+	// defaultZone is the UTM zone used when there are no tiles
+	defaultZone = 32
 )
 
 // IntStep is used for indices of squares. It is a separate type to make it easy to distinguish it from
-// easting/northing. IntStep values must be multiplied by 10 to get easting/northing
+// easting/northing. IntStep values must be multiplied by the spacing of the ElevationMap to get easting/northing
 type IntStep int
 
-// ElevationMap provides access to all elevation data
+// ElevationMap provides access to all elevation data. Positions are IntStep indices in the grid of one UTM zone
+// and spacing, but the ElevationMap can hold tiles from several zones and with several spacings. Tiles from other
+// zones are resampled to the grid when needed. Use InZone and WithSpacing to get an ElevationMap for another grid.
 type ElevationMap struct {
-	minEasting  float64
-	maxNorthing float64
-	mmapStructs [50][50]*mmap5000
+	grid  *zoneGrid
+	tiles *elevationTiles
+
+	// generation is set by Acquire
+	generation *generation
+}
+
+// zoneGrid gives access to the tiles in the grid of one UTM zone and spacing, or one of their downsampled levels
+type zoneGrid struct {
+	zone    int
+	spacing float64
+	level   int
+	tiles   *elevationTiles
+
+	// foreign contains the foreignMax of the maplets resampled from tiles in other zones, indexed by mapletKey
+	foreign sync.Map
+
+	// blockMax contains the blockMax of the blocks in the grid that are not stored in the tiles, indexed by blockKey
+	blockMax sync.Map
+}
+
+// tileIndex is the position of a tile in the grid of its zone and spacing, counted in tiles from the grid origin
+type tileIndex struct {
+	zone    int
+	spacing float64
+	e       int
+	n       int
+}
+
+// gridKey identifies a zoneGrid
+type gridKey struct {
+	zone    int
+	spacing float64
+	level   int
+}
+
+// ErrClosed is returned when using an ElevationMap that is closed
+var ErrClosed = errors.New("elevation map is closed")
+
+// elevationTiles holds all tiles indexed by LoadFiles, and the grids for each zone
+type elevationTiles struct {
+	index map[tileIndex]*lazyTile
+
+	// maxMapped is the maximum number of mapped tiles, or 0 for no limit
+	maxMapped int
+
+	// clock is increased every time a tile is mapped. It is accessed atomically.
+	clock int64
+
+	// knownTiles counts the tiles that have been mapped, which is when their maximum elevation is known. It is
+	// accessed atomically.
+	knownTiles int64
+
+	// acquired counts the calls to Acquire, so that tiles that failed to load are loaded again after the next one.
+	// It is accessed atomically.
+	acquired int64
+
+	// refMutex protects the fields below. refs is the number of users that have called Acquire without calling
+	// Release yet, and generations is the generations of users, with the current generation last.
+	refMutex    sync.Mutex
+	refs        int
+	generations []*generation
+	closed      bool
+	released    *sync.Cond
+
+	// mapped is the tiles that are mapped
+	mapped []*lazyTile
+
+	// maplets holds the maplets decompressed from compressed tiles, assembled from downsampled levels, or
+	// resampled from other zones
+	maplets *mapletCache
+
+	// zones is the zones that have tiles, ordered by number of tiles
+	zones []int
+
+	// spacings is the spacings that have tiles, finest first
+	spacings []float64
+
+	mutex sync.Mutex
+	grids map[gridKey]*zoneGrid
 }
 
 // ElevationMaplet is a small piece of the ElevationMap that fits in memory.
 // The contents is loaded from a memory mapped file
 type ElevationMaplet [ElevationMapletSize][ElevationMapletSize]Elevation16
 
-// Offsets returns minimum easting and maximum northing
+// Offsets returns minimum easting and maximum northing. The points in downsampled levels are in the middle of the
+// points they are computed from, so their grid is offset by half a point less than the spacing.
 func (em *ElevationMap) Offsets() (minEasting float64, maxNorthing float64) {
-	return em.minEasting, em.maxNorthing
+	offset := float64(em.grid.levelFactor()-1) * em.grid.spacing / 2
+	return gridMinEasting + offset, gridMaxNorthing - offset
+}
+
+// Zone returns the UTM zone used for easting/northing
+func (em *ElevationMap) Zone() int {
+	return em.grid.zone
+}
+
+// Spacing returns the number of meters between the elevation points in the grid, which is the length of an IntStep
+func (em *ElevationMap) Spacing() float64 {
+	return em.grid.spacing * float64(em.grid.levelFactor())
+}
+
+// Spacings returns the spacings that have tiles, finest first
+func (em *ElevationMap) Spacings() []float64 {
+	return em.tiles.spacings
+}
+
+// InZone returns an ElevationMap with the same tiles and level, using the grid of the given UTM zone
+func (em *ElevationMap) InZone(zone int) ElevationMap {
+	return em.inGrid(zone, em.grid.spacing, em.grid.level)
 }
 
-func (em *ElevationMap) lookupMmapStruct(e int, n int) *mmap5000 {
-	if e < 0 || e >= 50 || n < 0 || n >= 50 {
+// WithSpacing returns an ElevationMap with the same tiles, using the grid of the tiles with the given spacing.
+// Only the tiles with the spacing are used.
+func (em *ElevationMap) WithSpacing(spacing float64) ElevationMap {
+	return em.inGrid(em.grid.zone, spacing, 0)
+}
+
+func (em *ElevationMap) inGrid(zone int, spacing float64, level int) ElevationMap {
+	return ElevationMap{
+		grid:       em.tiles.levelGrid(zone, spacing, level),
+		tiles:      em.tiles,
+		generation: em.generation,
+	}
+}
+
+// Acquire must be called before using the ElevationMap, and the returned ElevationMap must be released with
+// Release when done. This prevents tiles from being unmapped while they are in use. It returns ErrClosed if the
+// ElevationMap is closed.
+func (em *ElevationMap) Acquire() (ElevationMap, error) {
+	t := em.tiles
+	t.refMutex.Lock()
+	defer t.refMutex.Unlock()
+
+	if t.closed {
+		return ElevationMap{}, ErrClosed
+	}
+	atomic.AddInt64(&t.acquired, 1)
+	acquired := *em
+	acquired.generation = t.generations[len(t.generations)-1]
+	acquired.generation.refs++
+	t.refs++
+	return acquired, nil
+}
+
+// Release must be called on the ElevationMap returned by Acquire when done using it
+func (em *ElevationMap) Release() {
+	t := em.tiles
+	t.refMutex.Lock()
+	defer t.refMutex.Unlock()
+
+	em.generation.refs--
+	t.unmapEvicted()
+	t.refs--
+	if t.refs == 0 {
+		t.released.Broadcast()
+	}
+}
+
+// Close unmaps all tiles. It waits until all users have called Release, and later calls to Acquire return
+// ErrClosed. The ElevationMap in other zones from InZone is closed too. Closing it again does nothing.
+func (em *ElevationMap) Close() error {
+	t := em.tiles
+	t.refMutex.Lock()
+	defer t.refMutex.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	for t.refs > 0 {
+		t.released.Wait()
+	}
+
+	var mapped []tileData
+	for _, tile := range t.mapped {
+		mapped = append(mapped, tile.swap(nil))
+	}
+	for _, g := range t.generations {
+		mapped = append(mapped, g.evicted...)
+	}
+	t.mapped, t.generations = nil, nil
+
+	var firstErr error
+	for _, m := range mapped {
+		if err := m.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ZoneFor returns the UTM zone to use for a viewpoint at the given lat/lng. This is the zone of a tile with any
+// spacing that contains the viewpoint, preferring the standard zone for the position.
+func (em *ElevationMap) ZoneFor(lat float64, lng float64) int {
+	standardZone := utmZone(lat, lng)
+	zones := append([]int{standardZone}, em.tiles.zones...)
+	for _, zone := range zones {
+		utm := NewUTM(zone)
+		easting, northing := utm.LatLngToUTM(lat, lng)
+		for _, spacing := range em.tiles.spacings {
+			if em.tiles.grid(zone, spacing).hasTile(easting, northing) {
+				return zone
+			}
+		}
+	}
+	return standardZone
+}
+
+// lookupMmapStruct returns the tile with the given tile indices, which is mapped if needed.
+// It returns nil if there is no tile, or if it can't be loaded.
+func (g *zoneGrid) lookupMmapStruct(e int, n int) tileData {
+	tile := g.tileAtIndex(e, n)
+	if tile == nil {
 		return nil
 	}
+	return g.tiles.lookup(tile)
+}
+
+// tileAtIndex returns the tile with the given tile indices, or nil if there is none. The tile is not mapped.
+func (g *zoneGrid) tileAtIndex(e int, n int) *lazyTile {
+	return g.tiles.index[tileIndex{zone: g.zone, spacing: g.spacing, e: e, n: n}]
+}
+
+// indexFor returns the tileIndex for the tile at the given position.
+// It returns false if the tile is not aligned with the grid, or the spacing is not supported.
+func indexFor(tile TilePosition) (tileIndex, bool) {
+	if checkSpacing(tile.Spacing) != nil {
+		return tileIndex{}, false
+	}
+	size := tile.size()
+	e := (tile.MinEasting - gridMinEasting) / size
+	n := (gridMaxNorthing - tile.MaxNorthing) / size
+	index := tileIndex{zone: tile.Zone, spacing: tile.Spacing, e: int(math.Round(e)), n: int(math.Round(n))}
+	aligned := math.Abs(e-math.Round(e))*size < tile.Spacing/2 && math.Abs(n-math.Round(n))*size < tile.Spacing/2
+	return index, aligned && index.e >= 0 && index.n >= 0
+}
 
-	return em.mmapStructs[e][n]
+// covered returns true if the index has a tile with one of the given spacings that overlaps the given tile, and has
+// the same or a coarser spacing. Finer tiles don't hide the tile, since the finest data is used where there is any.
+func (tiles *elevationTiles) covered(position TilePosition, spacings []float64) bool {
+	maxEasting := position.MinEasting + position.size()
+	minNorthing := position.MaxNorthing - position.size()
+	for _, spacing := range spacings {
+		if spacing < position.Spacing {
+			continue
+		}
+		size := spacing * bigSquareSize
+		for e := math.Floor((position.MinEasting - gridMinEasting) / size); gridMinEasting+e*size < maxEasting; e++ {
+			for n := math.Floor((gridMaxNorthing - position.MaxNorthing) / size); gridMaxNorthing-n*size > minNorthing; n++ {
+				if tiles.index[tileIndex{zone: position.Zone, spacing: spacing, e: int(e), n: int(n)}] != nil {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// containsSpacing returns true if the spacing is in spacings
+func containsSpacing(spacings []float64, spacing float64) bool {
+	for _, s := range spacings {
+		if s == spacing {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTile returns true if there is a tile in the grid that contains the given easting/northing. The tile is not
+// mapped.
+func (g *zoneGrid) hasTile(easting float64, northing float64) bool {
+	return g.tileAt(easting, northing) != nil
+}
+
+// SpacingAt returns the finest spacing of the tiles in the zone of the ElevationMap that contain the given
+// easting/northing, or the spacing of the ElevationMap if there are none. The tiles are not mapped.
+func (em *ElevationMap) SpacingAt(easting float64, northing float64) float64 {
+	for _, spacing := range em.tiles.spacings {
+		if em.tiles.grid(em.grid.zone, spacing).hasTile(easting, northing) {
+			return spacing
+		}
+	}
+	return em.grid.spacing
+}
+
+// tileAt returns the tile in the grid that contains the given easting/northing, or nil if there is none. The tile
+// is not mapped.
+func (g *zoneGrid) tileAt(easting float64, northing float64) *lazyTile {
+	size := g.spacing * bigSquareSize
+	return g.tiles.index[tileIndex{
+		zone:    g.zone,
+		spacing: g.spacing,
+		e:       int(math.Floor((easting - gridMinEasting) / size)),
+		n:       int(math.Floor((gridMaxNorthing - northing) / size)),
+	}]
 }
 
 func index2(x IntStep) int {
 	return int((x / ElevationMapletSize) % numberOfElevationMaplets)
 }
 
-// MaxElevation returns the maximum elevation in the ElevationMaplet
+// MaxElevation returns the maximum elevation in the ElevationMaplet, or -1 if there is no tile. It is at least 0.
 func (em *ElevationMap) MaxElevation(e IntStep, n IntStep) float64 {
 	if e < 0 || n < 0 {
 		return -1
 	}
+	if em.grid.level > 0 {
+		return em.BlockMaxElevation(e, n, ElevationMapletSize)
+	}
 
-	mmapStruct := em.lookupMmapStruct(int(e/bigSquareSize), int(n/bigSquareSize))
+	mmapStruct := em.grid.lookupMmapStruct(int(e/bigSquareSize), int(n/bigSquareSize))
 	if mmapStruct == nil {
-		return -1
+		return em.foreignMaxElevation(e, n)
 	}
 
-	return float64(mmapStruct.MaxElevations[index2(n)][index2(e)]) / Unit
+	return float64(mmapStruct.maxElevation(index2(n), index2(e))) * Elevation16Unit
 }
 
-// LookupElevationMaplet returns the ElevationMaplet for the given easting/northing
+// LookupElevationMaplet returns the ElevationMaplet for the given easting/northing, or nil if there is no tile.
+// Points without elevation data are NoData.
 func (em *ElevationMap) LookupElevationMaplet(e IntStep, n IntStep) *ElevationMaplet {
 	if e < 0 || n < 0 {
 		return nil
 	}
+	if em.grid.level > 0 {
+		return em.levelMaplet(e, n)
+	}
 
-	mmapStruct := em.lookupMmapStruct(int(e/bigSquareSize), int(n/bigSquareSize))
+	mmapStruct := em.grid.lookupMmapStruct(int(e/bigSquareSize), int(n/bigSquareSize))
 	if mmapStruct == nil {
-		return nil
+		return em.foreignMaplet(e, n)
 	}
 
-	return &mmapStruct.Elevations[index2(n)][index2(e)]
+	return mmapStruct.maplet(index2(n), index2(e))
 }
 
-// Elevation returns the elevation at a given easting/northing
+// Elevation returns the elevation at a given easting/northing. It returns NaN if there is no elevation data.
 func (em *ElevationMap) Elevation(easting IntStep, northing IntStep) float64 {
-	mmapStruct := em.lookupMmapStruct(int(easting/bigSquareSize), int(northing/bigSquareSize))
-	if mmapStruct == nil {
-		return -1
+	maplet := em.LookupElevationMaplet(easting, northing)
+	if maplet == nil {
+		return math.NaN()
+	}
+	elevation := maplet[northing%ElevationMapletSize][easting%ElevationMapletSize]
+	if elevation == NoData {
+		return math.NaN()
 	}
-	return float64(mmapStruct.Elevations[index2(northing)][index2(easting)][northing%ElevationMapletSize][easting%ElevationMapletSize]) * Elevation16Unit
+	return float64(elevation) * Elevation16Unit
 }
 
-// LoadFiles loads the given fNames and returns it as an ElevationMap
-func LoadFiles(datasetReader Reader, mmapFileDir string, fNames []string) (ElevationMap, error) {
-	mmapStructs := []*mmap5000{}
-	allElevations := ElevationMap{
-		minEasting:  math.MaxFloat64,
-		maxNorthing: -math.MaxFloat64,
+// LoadOptions controls how LoadFiles loads the files
+type LoadOptions struct {
+	// Verify enables verification of the checksums in the mmap files
+	Verify bool
+
+	// MaxMappedTiles is the maximum number of tiles that are mapped at the same time, or 0 for no limit.
+	// The least recently used tile is unmapped when the limit is exceeded.
+	MaxMappedTiles int
+
+	// Compress selects compressed mmap files, where each maplet is compressed on its own and decompressed when
+	// it is first used. They are much smaller, but slower to use.
+	Compress bool
+
+	// MapletCacheSize is the maximum number of decompressed maplets, maplets assembled from downsampled levels,
+	// and maplets resampled from other zones, kept in memory, or 0 for the default of 1024 maplets (80 MB)
+	MapletCacheSize int
+}
+
+// FileReport is the result of loading one of the files given to LoadFiles
+type FileReport struct {
+	FName string
+
+	// Loaded is the position of the tiles that were indexed from the file
+	Loaded []TilePosition
+
+	// Skipped is the reason for each tile, or the whole file, that was skipped
+	Skipped []error
+}
+
+// LoadReport contains a FileReport for each file given to LoadFiles, in the same order
+type LoadReport []FileReport
+
+// Err returns an error for the first skipped file or tile, or nil if nothing was skipped
+func (r LoadReport) Err() error {
+	var first error
+	count := 0
+	for _, f := range r {
+		for _, err := range f.Skipped {
+			if first == nil {
+				first = fmt.Errorf("%s: %w", f.FName, err)
+			}
+			count++
+		}
+	}
+	if count > 1 {
+		return fmt.Errorf("%w (and %d more)", first, count-1)
+	}
+	return first
+}
+
+// LoadFiles indexes the tiles in the given fNames and returns them as an ElevationMap. Tiles from Locators and
+// TileReaders are converted and mapped the first time they are used, while tiles from other Readers are loaded
+// immediately. Errors from loading tiles later are logged.
+//
+// Files and tiles that can't be indexed are skipped, and the reason is given in the LoadReport. This includes
+// tiles that are not aligned with the grid, or overlap a tile that is already indexed. Tiles from a TileReader
+// that are covered by other files are not reported.
+func LoadFiles(datasetReader Reader, mmapFileDir string, fNames []string, options LoadOptions) (ElevationMap, LoadReport, error) {
+	cacheSize := options.MapletCacheSize
+	if cacheSize <= 0 {
+		cacheSize = defaultMapletCacheSize
+	}
+	tiles := elevationTiles{
+		index:       map[tileIndex]*lazyTile{},
+		maxMapped:   options.MaxMappedTiles,
+		generations: []*generation{{}},
+		maplets:     newMapletCache(cacheSize),
+		grids:       map[gridKey]*zoneGrid{},
+	}
+	tiles.released = sync.NewCond(&tiles.refMutex)
+	format := tileFormat{compress: options.Compress, cache: tiles.maplets, verify: options.Verify}
+
+	removeStaleTempFiles(mmapFileDir)
+	report := make(LoadReport, len(fNames))
+
+	// addTile adds a tile to the index. The tile is already mapped if m is set.
+	addTile := func(f *FileReport, tile *lazyTile, m tileData) {
+		index, ok := indexFor(tile.position)
+		if err := checkSpacing(tile.position.Spacing); err != nil {
+			f.Skipped = append(f.Skipped, fmt.Errorf("tile %v: %w", tile.position, err))
+		} else if !ok {
+			f.Skipped = append(f.Skipped, fmt.Errorf("tile %v is not aligned with the grid", tile.position))
+		} else if other, ok := tiles.index[index]; ok {
+			f.Skipped = append(f.Skipped, fmt.Errorf("tile %v overlaps %s", tile.position, other.fName))
+		} else {
+			tiles.index[index] = tile
+			f.Loaded = append(f.Loaded, tile.position)
+			if m != nil {
+				tile.swap(m)
+			}
+			return
+		}
+		if m != nil {
+			m.Close()
+		}
+	}
+
+	// Files from a TileReader are loaded last, and only where no other file has data
+	var tileReports []*FileReport
+	for i := range fNames {
+		f := &report[i]
+		f.FName = fNames[i]
+
+		reader, err := readerFor(datasetReader, f.FName)
+		if err != nil {
+			f.Skipped = append(f.Skipped, err)
+			continue
+		}
+		if _, ok := reader.(TileReader); ok {
+			tileReports = append(tileReports, f)
+			continue
+		}
+
+		fName := f.FName
+		tile := &lazyTile{
+			fName: fName,
+			load: func() (tileData, error) {
+				return loadAsMmap(reader, mmapFileDir, fName, format)
+			},
+		}
+
+		if locator, ok := reader.(Locator); ok {
+			if tile.position, err = locator.Locate(fName); err != nil {
+				f.Skipped = append(f.Skipped, err)
+				continue
+			}
+			addTile(f, tile, nil)
+			continue
+		}
+
+		m, err := tile.load()
+		if err != nil {
+			f.Skipped = append(f.Skipped, err)
+			continue
+		}
+		tile.position = m.position()
+		addTile(f, tile, m)
 	}
 
-	for _, fName := range fNames {
-		mmapStruct, err := loadAsMmap(datasetReader, mmapFileDir, fName)
+	// Tiles from other files take priority over overlapping tiles from a TileReader, also with other spacings
+	var otherSpacings []float64
+	for index := range tiles.index {
+		if !containsSpacing(otherSpacings, index.spacing) {
+			otherSpacings = append(otherSpacings, index.spacing)
+		}
+	}
+
+	for _, f := range tileReports {
+		reader, _ := readerFor(datasetReader, f.FName)
+		tileReader := reader.(TileReader)
+		positions, err := tileReader.Tiles(f.FName)
 		if err != nil {
-			fmt.Printf("%s: %v\n", fName, err)
+			f.Skipped = append(f.Skipped, err)
 			continue
 		}
-		allElevations.maxNorthing = math.Max(allElevations.maxNorthing, mmapStruct.NorthingMax)
-		allElevations.minEasting = math.Min(allElevations.minEasting, mmapStruct.EastingMin)
 
-		mmapStructs = append(mmapStructs, mmapStruct)
+		for _, position := range positions {
+			if tiles.covered(position, otherSpacings) {
+				continue
+			}
+
+			fName, position := f.FName, position
+			addTile(f, &lazyTile{
+				position: position,
+				fName:    fName,
+				load: func() (tileData, error) {
+					return loadTileAsMmap(tileReader, mmapFileDir, fName, position, format)
+				},
+			}, nil)
+		}
+	}
+
+	// Tiles that were loaded immediately count towards MaxMappedTiles
+	tiles.refMutex.Lock()
+	for _, tile := range tiles.index {
+		if m := tile.loaded(); m != nil {
+			tiles.addMapped(tile, m)
+		}
 	}
-	for _, mmapStruct := range mmapStructs {
-		x := (int(mmapStruct.EastingMin) - int(allElevations.minEasting)) / (bigSquareSize * Unit)
-		y := (int(allElevations.maxNorthing) - int(mmapStruct.NorthingMax)) / (bigSquareSize * Unit)
-		allElevations.mmapStructs[x][y] = mmapStruct
+	tiles.refMutex.Unlock()
+
+	count := map[int]int{}
+	spacings := map[float64]bool{}
+	for index := range tiles.index {
+		if count[index.zone] == 0 {
+			tiles.zones = append(tiles.zones, index.zone)
+		}
+		count[index.zone]++
+		if !spacings[index.spacing] {
+			tiles.spacings = append(tiles.spacings, index.spacing)
+			spacings[index.spacing] = true
+		}
+	}
+	sort.Float64s(tiles.spacings)
+	sort.Ints(tiles.zones)
+	sort.SliceStable(tiles.zones, func(i, j int) bool {
+		return count[tiles.zones[i]] > count[tiles.zones[j]]
+	})
+
+	zone := defaultZone
+	if len(tiles.zones) > 0 {
+		zone = tiles.zones[0]
+	}
+
+	// The default spacing is used if there are tiles with it
+	spacing := float64(Unit)
+	if len(tiles.spacings) > 0 && !spacings[spacing] {
+		spacing = tiles.spacings[0]
 	}
 
-	return allElevations, nil
+	allElevations := ElevationMap{tiles: &tiles}
+	return allElevations.inGrid(zone, spacing, 0), report, nil
 }
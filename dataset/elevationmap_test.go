@@ -0,0 +1,544 @@
+package dataset
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testReader produces tiles with elevations given by a function of lat/lng, which is the same in all zones.
+// The elevation is 0 if there is no function.
+type testReader struct {
+	tiles     map[string]TilePosition
+	elevation func(lat, lng float64) float64
+}
+
+func (r *testReader) ReadFile(fname string) (buffer [][]float32, tile TilePosition, err error) {
+	tile, ok := r.tiles[filepath.Base(fname)]
+	if !ok {
+		return nil, tile, errors.New("corrupt file")
+	}
+
+	buffer = make([][]float32, bigSquareSize+1)
+	for i := range buffer {
+		buffer[i] = make([]float32, bigSquareSize+1)
+	}
+	if r.elevation == nil {
+		return buffer, tile, nil
+	}
+
+	// The elevation is computed for every 10th point and interpolated in between, since projection is slow
+	const step = 10
+	utm := NewUTM(tile.Zone)
+	for i := 0; i <= bigSquareSize; i += step {
+		for j := 0; j <= bigSquareSize; j += step {
+			lat, lng := utm.UTMToLatLng(tile.MinEasting+float64(j)*tile.Spacing, tile.MaxNorthing-float64(i)*tile.Spacing)
+			buffer[i][j] = float32(r.elevation(lat, lng))
+		}
+	}
+	for i := range buffer {
+		i0 := i / step * step
+		if i0 == bigSquareSize {
+			i0 -= step
+		}
+		fi := float32(i-i0) / step
+		for j := range buffer[i] {
+			j0 := j / step * step
+			if j0 == bigSquareSize {
+				j0 -= step
+			}
+			fj := float32(j-j0) / step
+			buffer[i][j] = (buffer[i0][j0]*(1-fj)+buffer[i0][j0+step]*fj)*(1-fi) +
+				(buffer[i0+step][j0]*(1-fj)+buffer[i0+step][j0+step]*fj)*fi
+		}
+	}
+	return buffer, tile, nil
+}
+
+func (r *testReader) Locate(fname string) (TilePosition, error) {
+	tile, ok := r.tiles[filepath.Base(fname)]
+	if !ok {
+		return tile, errors.New("corrupt file")
+	}
+	return tile, nil
+}
+
+// eagerTestReader is a testReader that isn't a Locator, so the tiles are loaded immediately
+type eagerTestReader struct {
+	r *testReader
+}
+
+func (r eagerTestReader) ReadFile(fname string) (buffer [][]float32, tile TilePosition, err error) {
+	return r.r.ReadFile(fname)
+}
+
+// tileAt returns the position of the tile with the default spacing containing lat/lng in the given zone
+func tileAt(zone int, lat float64, lng float64) TilePosition {
+	return tileWithSpacingAt(zone, lat, lng, Unit)
+}
+
+// tileWithSpacingAt returns the position of the tile with the given spacing containing lat/lng in the given zone
+func tileWithSpacingAt(zone int, lat float64, lng float64, spacing float64) TilePosition {
+	utm := NewUTM(zone)
+	easting, northing := utm.LatLngToUTM(lat, lng)
+	size := spacing * bigSquareSize
+	return TilePosition{
+		Zone:        zone,
+		MinEasting:  math.Floor(easting/size) * size,
+		MaxNorthing: math.Ceil(northing/size) * size,
+		Spacing:     spacing,
+	}
+}
+
+// loadTestFiles loads tiles from a testReader, using empty files in a temporary directory. The files are loaded
+// in sorted order, and the corrupt files can't be read.
+func loadTestFiles(t *testing.T, r *testReader, corrupt ...string) (ElevationMap, LoadReport, func()) {
+	return loadTestFilesWith(t, r, r, LoadOptions{Verify: true}, corrupt...)
+}
+
+// loadTestFilesWith is like loadTestFiles, using the given Reader and options
+func loadTestFilesWith(t *testing.T, r *testReader, reader Reader, options LoadOptions, corrupt ...string) (ElevationMap, LoadReport, func()) {
+	dir, err := ioutil.TempDir("", "elevationmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fNames []string
+	for name := range r.tiles {
+		fNames = append(fNames, filepath.Join(dir, name))
+	}
+	for _, name := range corrupt {
+		fNames = append(fNames, filepath.Join(dir, name))
+	}
+	sort.Strings(fNames)
+
+	for _, fName := range fNames {
+		if err = ioutil.WriteFile(fName, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	em, report, err := LoadFiles(reader, dir, fNames, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return em, report, func() {
+		em.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestElevationMapAcrossZones(t *testing.T) {
+	r := testReader{
+		tiles: map[string]TilePosition{
+			"west.dem": tileAt(32, 65, 11.8),
+			"east.dem": tileAt(33, 65, 12.2),
+		},
+		elevation: func(lat, lng float64) float64 {
+			return 1000*(lat-64) + 100*lng
+		},
+	}
+
+	for _, options := range []LoadOptions{{Verify: true}, {Verify: true, Compress: true}} {
+		em, report, cleanup := loadTestFilesWith(t, &r, &r, options)
+		defer cleanup()
+
+		if err := report.Err(); err != nil {
+			t.Fatal(err)
+		}
+		testElevationMapAcrossZones(t, em, &r)
+	}
+}
+
+func TestForeignMapletCache(t *testing.T) {
+	r := testReader{
+		tiles: map[string]TilePosition{
+			"west.dem": tileAt(32, 65, 11.8),
+		},
+		elevation: func(lat, lng float64) float64 {
+			return 1000*(lat-64) + 100*lng
+		},
+	}
+
+	const cacheSize = 2
+	em, report, cleanup := loadTestFilesWith(t, &r, &r, LoadOptions{MapletCacheSize: cacheSize})
+	defer cleanup()
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The maplets in zone 33 are resampled from the tile in zone 32
+	zoneMap := em.InZone(33)
+	utm := NewUTM(33)
+	easting, northing := utm.LatLngToUTM(65, 11.8)
+	minEasting, maxNorthing := zoneMap.Offsets()
+	e := IntStep(math.Round((easting - minEasting) / Unit))
+	n := IntStep(math.Round((maxNorthing - northing) / Unit))
+	want := zoneMap.Elevation(e, n)
+	if math.IsNaN(want) {
+		t.Fatalf("no elevation resampled from the other zone")
+	}
+
+	// They are kept in the maplet cache, and resampled again when they are removed from it
+	key := mapletKey{grid: zoneMap.grid, i: int(n / ElevationMapletSize), j: int(e / ElevationMapletSize)}
+	if em.tiles.maplets.get(key) == nil {
+		t.Errorf("the resampled maplet is not in the cache")
+	}
+	for i := IntStep(1); i <= 10; i++ {
+		zoneMap.LookupElevationMaplet(e-i*ElevationMapletSize, n)
+	}
+	if l := em.tiles.maplets.lru.Len(); l > cacheSize {
+		t.Errorf("%d maplets in the cache, want at most %d", l, cacheSize)
+	}
+	if em.tiles.maplets.get(key) != nil {
+		t.Errorf("the resampled maplet is still in the cache")
+	}
+	if got := zoneMap.Elevation(e, n); got != want {
+		t.Errorf("elevation is %v after the maplet is resampled again, want %v", got, want)
+	}
+	if max := zoneMap.MaxElevation(e, n); max < want {
+		t.Errorf("maximum elevation of the maplet is %v, below %v", max, want)
+	}
+}
+
+// testElevationMapAcrossZones checks the elevations and zones of the ElevationMap loaded in
+// TestElevationMapAcrossZones
+func testElevationMapAcrossZones(t *testing.T, em ElevationMap, r *testReader) {
+	for _, zone := range []int{32, 33} {
+		zoneMap := em.InZone(zone)
+		utm := NewUTM(zone)
+		minEasting, maxNorthing := zoneMap.Offsets()
+		for _, p := range [][2]float64{{65, 11.8}, {65, 12.2}, {65.1, 12.05}} {
+			easting, northing := utm.LatLngToUTM(p[0], p[1])
+			e := IntStep(math.Round((easting - minEasting) / Unit))
+			n := IntStep(math.Round((maxNorthing - northing) / Unit))
+
+			// Compare with the elevation at the grid point
+			lat, lng := utm.UTMToLatLng(minEasting+float64(e*Unit), maxNorthing-float64(n*Unit))
+			want := r.elevation(lat, lng)
+			if got := zoneMap.Elevation(e, n); math.Abs(got-want) > 0.2 {
+				t.Errorf("elevation at %v in zone %d is %v, want %v", p, zone, got, want)
+			}
+		}
+	}
+
+	if zone := em.ZoneFor(65, 12.2); zone != 33 {
+		t.Errorf("ZoneFor(65, 12.2) = %d, want 33", zone)
+	}
+
+	if zone := em.ZoneFor(65, 11.8); zone != 32 {
+		t.Errorf("ZoneFor(65, 11.8) = %d, want 32", zone)
+	}
+}
+
+func TestElevationMapTiles(t *testing.T) {
+	aligned := tileAt(32, 61, 9)
+	misaligned := TilePosition{Zone: 32, MinEasting: aligned.MinEasting + 10_000, MaxNorthing: aligned.MaxNorthing + tileSize, Spacing: Unit}
+	far := tileAt(32, 30, 9)
+
+	r := testReader{
+		tiles: map[string]TilePosition{
+			"a.dem": aligned,
+			"b.dem": aligned,
+			"c.dem": misaligned,
+			"d.dem": far,
+		},
+	}
+
+	em, report, cleanup := loadTestFilesWith(t, &r, eagerTestReader{&r}, LoadOptions{}, "e.dem")
+	defer cleanup()
+
+	for _, test := range []struct {
+		tile TilePosition
+		want float64
+	}{
+		{aligned, 0},
+		{TilePosition{Zone: 32, MinEasting: aligned.MinEasting, MaxNorthing: aligned.MaxNorthing + tileSize, Spacing: Unit}, math.NaN()},
+		{far, 0},
+	} {
+		e := IntStep((test.tile.MinEasting - gridMinEasting) / Unit)
+		n := IntStep((gridMaxNorthing - test.tile.MaxNorthing) / Unit)
+		if got := em.Elevation(e+10, n+10); got != test.want && !(math.IsNaN(got) && math.IsNaN(test.want)) {
+			t.Errorf("elevation in tile %v is %v, want %v", test.tile, got, test.want)
+		}
+	}
+
+	// The corrupt file, the overlapping tile and the misaligned tile are skipped
+	for i, want := range []struct {
+		loaded  int
+		skipped int
+	}{
+		{1, 0},
+		{0, 1},
+		{0, 1},
+		{1, 0},
+		{0, 1},
+	} {
+		f := report[i]
+		if len(f.Loaded) != want.loaded || len(f.Skipped) != want.skipped {
+			t.Errorf("%s: loaded %v and skipped %v, want %d and %d",
+				filepath.Base(f.FName), f.Loaded, f.Skipped, want.loaded, want.skipped)
+		}
+	}
+
+	if err := report.Err(); err == nil || !strings.Contains(err.Error(), "and 2 more") {
+		t.Errorf("unexpected report error %v", err)
+	}
+}
+
+func TestElevationMapClose(t *testing.T) {
+	tile := tileAt(32, 61, 9)
+	r := testReader{
+		tiles: map[string]TilePosition{"a.dem": tile},
+	}
+
+	em, _, cleanup := loadTestFiles(t, &r)
+	defer cleanup()
+
+	acquired, err := em.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := IntStep((tile.MinEasting - gridMinEasting) / Unit)
+	n := IntStep((gridMaxNorthing - tile.MaxNorthing) / Unit)
+	if got := acquired.Elevation(e, n); got != 0 {
+		t.Errorf("elevation before close is %v, want 0", got)
+	}
+
+	closed := make(chan error)
+	go func() {
+		closed <- em.Close()
+	}()
+
+	// Close waits for Release
+	time.Sleep(10 * time.Millisecond)
+	if got := acquired.Elevation(e, n); got != 0 {
+		t.Errorf("elevation before release is %v, want 0", got)
+	}
+	acquired.Release()
+
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := em.Acquire(); err != ErrClosed {
+		t.Errorf("Acquire after Close returned %v, want %v", err, ErrClosed)
+	}
+	if got := em.Elevation(e, n); !math.IsNaN(got) {
+		t.Errorf("elevation after close is %v, want NaN", got)
+	}
+}
+
+func TestElevationMapMaxMappedTiles(t *testing.T) {
+	var positions []TilePosition
+	r := testReader{tiles: map[string]TilePosition{}}
+	for i, name := range []string{"a.dem", "b.dem", "c.dem"} {
+		positions = append(positions, tileAt(32, 61, 6+float64(i)))
+		r.tiles[name] = positions[i]
+	}
+
+	em, _, cleanup := loadTestFilesWith(t, &r, &r, LoadOptions{MaxMappedTiles: 2})
+	defer cleanup()
+
+	if len(em.tiles.mapped) != 0 {
+		t.Fatalf("%d tiles mapped before use", len(em.tiles.mapped))
+	}
+
+	// Tiles that are evicted while the map is acquired are not unmapped
+	acquired, err := em.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// use reads an elevation from the tile at the given position
+	use := func(tile TilePosition) {
+		e := IntStep((tile.MinEasting - gridMinEasting) / Unit)
+		n := IntStep((gridMaxNorthing - tile.MaxNorthing) / Unit)
+		if got := em.Elevation(e, n); got != 0 {
+			t.Errorf("elevation in %v is %v, want 0", tile, got)
+		}
+	}
+
+	// isMapped returns true if the tile at the given position is mapped
+	isMapped := func(tile TilePosition) bool {
+		index, _ := indexFor(tile)
+		return em.tiles.index[index].mapped != nil
+	}
+
+	use(positions[0])
+	use(positions[1])
+	use(positions[2])
+	if isMapped(positions[0]) || !isMapped(positions[1]) || !isMapped(positions[2]) {
+		t.Errorf("the least recently used tile was not evicted")
+	}
+
+	// The evicted tile is unmapped when it is no longer in use
+	if len(em.tiles.generations) != 2 || len(em.tiles.generations[0].evicted) != 1 {
+		t.Errorf("evicted tile was unmapped while in use")
+	}
+	acquired.Release()
+	if len(em.tiles.generations) != 1 {
+		t.Errorf("evicted tile was not unmapped after release")
+	}
+
+	// Evicted tiles are mapped again when used
+	use(positions[0])
+	if !isMapped(positions[0]) || isMapped(positions[1]) || !isMapped(positions[2]) {
+		t.Errorf("the least recently used tile was not evicted")
+	}
+}
+
+// flakyTestReader is a testReader that fails to read the first files
+type flakyTestReader struct {
+	r        *testReader
+	failures int
+}
+
+func (r *flakyTestReader) ReadFile(fname string) (buffer [][]float32, tile TilePosition, err error) {
+	if r.failures > 0 {
+		r.failures--
+		return nil, tile, errors.New("transient error")
+	}
+	return r.r.ReadFile(fname)
+}
+
+func (r *flakyTestReader) Locate(fname string) (TilePosition, error) {
+	return r.r.Locate(fname)
+}
+
+func TestElevationMapRetry(t *testing.T) {
+	tile := tileAt(32, 61, 9)
+	r := testReader{tiles: map[string]TilePosition{"a.dem": tile}}
+	em, report, cleanup := loadTestFilesWith(t, &r, &flakyTestReader{r: &r, failures: 1}, LoadOptions{})
+	defer cleanup()
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := IntStep((tile.MinEasting - gridMinEasting) / Unit)
+	n := IntStep((gridMaxNorthing - tile.MaxNorthing) / Unit)
+
+	// The tile is not loaded again until the next call to Acquire
+	acquired, err := em.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if got := acquired.Elevation(e, n); !math.IsNaN(got) {
+			t.Errorf("elevation is %v after the tile failed to load", got)
+		}
+	}
+	acquired.Release()
+
+	acquired, err = em.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer acquired.Release()
+	if got := acquired.Elevation(e, n); got != 0 {
+		t.Errorf("elevation is %v after the tile is loaded again, want 0", got)
+	}
+}
+
+func TestElevationMapSpacings(t *testing.T) {
+	unsupported := tileAt(32, 62, 9)
+	unsupported.Spacing = 3
+	r := testReader{
+		tiles: map[string]TilePosition{
+			"a.dem": tileAt(32, 61, 9),
+			"b.dem": tileWithSpacingAt(32, 61, 9, 50),
+			"c.dem": unsupported,
+		},
+		elevation: func(lat, lng float64) float64 {
+			return 1000*(lat-60) + 100*lng
+		},
+	}
+
+	em, report, cleanup := loadTestFiles(t, &r)
+	defer cleanup()
+
+	if err := report.Err(); err == nil || !strings.Contains(err.Error(), "unsupported spacing 3") {
+		t.Errorf("unexpected report error %v", err)
+	}
+	if got := em.Spacings(); len(got) != 2 || got[0] != 10 || got[1] != 50 {
+		t.Errorf("Spacings() = %v, want [10 50]", got)
+	}
+	if em.Spacing() != Unit {
+		t.Errorf("Spacing() = %v, want %v", em.Spacing(), Unit)
+	}
+
+	utm := NewUTM(32)
+	easting, northing := utm.LatLngToUTM(61.1, 9.1)
+
+	// The finest tile under a position gives the spacing there
+	for _, tc := range []struct {
+		lat, lng float64
+		want     float64
+	}{
+		{61.1, 9.1, 10},
+		{62, 9.1, 50},
+		{65, 9, 10},
+	} {
+		e, n := utm.LatLngToUTM(tc.lat, tc.lng)
+		if got := em.SpacingAt(e, n); got != tc.want {
+			t.Errorf("SpacingAt(%v, %v) = %v, want %v", tc.lat, tc.lng, got, tc.want)
+		}
+	}
+
+	for _, spacing := range []float64{10, 50} {
+		m := em.WithSpacing(spacing)
+		if other := m.InZone(33); other.Spacing() != spacing {
+			t.Errorf("InZone changed the spacing %v", spacing)
+		}
+
+		minEasting, maxNorthing := m.Offsets()
+		e := IntStep(math.Round((easting - minEasting) / spacing))
+		n := IntStep(math.Round((maxNorthing - northing) / spacing))
+		lat, lng := utm.UTMToLatLng(minEasting+float64(e)*spacing, maxNorthing-float64(n)*spacing)
+		if got, want := m.Elevation(e, n), r.elevation(lat, lng); math.Abs(got-want) > 0.2 {
+			t.Errorf("elevation with spacing %v is %v, want %v", spacing, got, want)
+		}
+	}
+}
+
+func TestElevationTilesCovered(t *testing.T) {
+	// A 20 meter tile covers 4 10 meter tiles, and a 2 meter tile covers a part of one 10 meter tile
+	coarse := TilePosition{Zone: 32, MinEasting: 400_000, MaxNorthing: 6_900_000, Spacing: 20}
+	fine := TilePosition{Zone: 32, MinEasting: 560_000, MaxNorthing: 6_890_000, Spacing: 2}
+	tiles := elevationTiles{index: map[tileIndex]*lazyTile{}}
+	for _, position := range []TilePosition{coarse, fine} {
+		index, ok := indexFor(position)
+		if !ok {
+			t.Fatalf("tile %v is not aligned", position)
+		}
+		tiles.index[index] = &lazyTile{position: position}
+	}
+
+	for _, tc := range []struct {
+		easting, northing float64
+		covered           bool
+	}{
+		{400_000, 6_900_000, true},
+		{450_000, 6_850_000, true},
+		{350_000, 6_900_000, false},
+		{500_000, 6_900_000, false},
+		{450_000, 6_800_000, false},
+		{550_000, 6_900_000, false},
+	} {
+		position := TilePosition{Zone: 32, MinEasting: tc.easting, MaxNorthing: tc.northing, Spacing: Unit}
+		if got := tiles.covered(position, []float64{20, 2}); got != tc.covered {
+			t.Errorf("tile at %v, %v is covered: %v", tc.easting, tc.northing, got)
+		}
+		position.Zone = 33
+		if tiles.covered(position, []float64{20, 2}) {
+			t.Errorf("tile at %v, %v in another zone is covered", tc.easting, tc.northing)
+		}
+	}
+}
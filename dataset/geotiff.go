@@ -0,0 +1,480 @@
+package dataset
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// TIFF tags used to read GeoTIFF elevation data
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPredictor       = 317
+	tiffTileWidth       = 322
+	tiffTileLength      = 323
+	tiffTileOffsets     = 324
+	tiffTileByteCounts  = 325
+	tiffSampleFormat    = 339
+
+	// gdalNoData is the ASCII encoded elevation value used for points without data
+	gdalNoData = 42113
+
+	geoTIFFModelPixelScale  = 33550
+	geoTIFFModelTiepoint    = 33922
+	geoTIFFGeoKeyDirectory  = 34735
+	geoKeyRasterType        = 1025
+	geoKeyProjectedCSType   = 3072
+	geoKeyProjLinearUnits   = 3076
+	geoKeyRasterPixelIsArea = 1
+	geoKeyLinearUnitMeter   = 9001
+)
+
+// Supported values for tiffCompression, tiffPredictor and tiffSampleFormat
+const (
+	tiffCompressionNone         = 1
+	tiffCompressionLZW          = 5
+	tiffCompressionDeflate      = 8
+	tiffCompressionAdobeDeflate = 32946
+
+	tiffPredictorNone          = 1
+	tiffPredictorHorizontal    = 2
+	tiffPredictorFloatingPoint = 3
+
+	tiffSampleFormatInt   = 2
+	tiffSampleFormatFloat = 3
+)
+
+// GeoTIFF reads elevation data from GeoTIFF files with a single band of Float32 or Int16 values in UTM coordinates
+type GeoTIFF struct{}
+
+// ReadFile reads the given GeoTIFF file and returns the contents
+func (g *GeoTIFF) ReadFile(fname string) (buffer [][]float32, tile TilePosition, err error) {
+	log.Printf("reading %s", fname)
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, tile, err
+	}
+
+	r, err := readGeoTIFF(data)
+	if err != nil {
+		return nil, tile, fmt.Errorf("failed to read tiff file: %w", err)
+	}
+
+	if err = checkSpacing(r.resolution); err != nil {
+		return nil, tile, fmt.Errorf("unexpected pixel size: %w", err)
+	}
+
+	buffer, tile.MinEasting, tile.MaxNorthing, err = r.alignedBuffer(readerAlignment, r.resolution, bigSquareSize+1)
+	if err != nil {
+		return nil, tile, fmt.Errorf("failed to read tiff file: %w", err)
+	}
+	tile.Zone = r.zone
+	tile.Spacing = r.resolution
+	return buffer, tile, nil
+}
+
+// geoTIFFHeaderSize is the number of bytes read by Locate. The whole file is read if the image file directory
+// isn't within this size.
+const geoTIFFHeaderSize = 1 << 20
+
+// Locate returns the position of the tile in the given GeoTIFF file, using only the image file directory
+func (g *GeoTIFF) Locate(fname string) (TilePosition, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return TilePosition{}, err
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, geoTIFFHeaderSize))
+	if err != nil {
+		return TilePosition{}, err
+	}
+
+	_, r, err := readGeoTIFFHeader(data)
+	if err != nil && len(data) == geoTIFFHeaderSize {
+		if data, err = ioutil.ReadFile(fname); err != nil {
+			return TilePosition{}, err
+		}
+		_, r, err = readGeoTIFFHeader(data)
+	}
+	if err != nil {
+		return TilePosition{}, fmt.Errorf("failed to read tiff file: %w", err)
+	}
+	return r.tilePosition(), nil
+}
+
+// tiffIFD holds the tags of the first image file directory in a TIFF file
+type tiffIFD struct {
+	data  []byte
+	order binary.ByteOrder
+	tags  map[uint16][]float64
+}
+
+func (ifd *tiffIFD) value(tag uint16, defaultValue float64) float64 {
+	if v, ok := ifd.tags[tag]; ok && len(v) > 0 {
+		return v[0]
+	}
+	return defaultValue
+}
+
+func (ifd *tiffIFD) intValue(tag uint16, defaultValue int) int {
+	return int(ifd.value(tag, float64(defaultValue)))
+}
+
+// typeSize returns the number of bytes for each value of the given TIFF field type
+func tiffTypeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7:
+		return 1
+	case 3, 8:
+		return 2
+	case 4, 9, 11:
+		return 4
+	case 5, 10, 12:
+		return 8
+	}
+	return 0
+}
+
+func readTIFFIFD(data []byte) (*tiffIFD, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("file too short")
+	}
+
+	ifd := tiffIFD{data: data, tags: map[uint16][]float64{}}
+	switch string(data[:2]) {
+	case "II":
+		ifd.order = binary.LittleEndian
+	case "MM":
+		ifd.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a tiff file")
+	}
+
+	if ifd.order.Uint16(data[2:]) != 42 {
+		return nil, fmt.Errorf("unsupported tiff version %d", ifd.order.Uint16(data[2:]))
+	}
+
+	offset := int(ifd.order.Uint32(data[4:]))
+	if offset+2 > len(data) {
+		return nil, fmt.Errorf("invalid directory offset")
+	}
+	count := int(ifd.order.Uint16(data[offset:]))
+	if offset+2+count*12 > len(data) {
+		return nil, fmt.Errorf("invalid directory size")
+	}
+
+	for i := 0; i < count; i++ {
+		entry := data[offset+2+i*12:]
+		tag := ifd.order.Uint16(entry)
+		typ := ifd.order.Uint16(entry[2:])
+		n := int(ifd.order.Uint32(entry[4:]))
+		size := tiffTypeSize(typ)
+		if size == 0 {
+			continue
+		}
+
+		valueData := entry[8:12]
+		if n*size > 4 {
+			valueOffset := int(ifd.order.Uint32(entry[8:]))
+			if valueOffset+n*size > len(data) {
+				return nil, fmt.Errorf("invalid value offset for tag %d", tag)
+			}
+			valueData = data[valueOffset : valueOffset+n*size]
+		}
+
+		// The only ASCII tag used is gdalNoData, which is stored as the number it holds
+		if typ == 2 {
+			text := strings.TrimSpace(strings.TrimRight(string(valueData[:n]), "\x00"))
+			if v, err := strconv.ParseFloat(text, 64); err == nil && tag == gdalNoData {
+				ifd.tags[tag] = []float64{v}
+			}
+			continue
+		}
+
+		values := make([]float64, n)
+		for j := range values {
+			v := valueData[j*size:]
+			switch typ {
+			case 1, 7:
+				values[j] = float64(v[0])
+			case 6:
+				values[j] = float64(int8(v[0]))
+			case 3:
+				values[j] = float64(ifd.order.Uint16(v))
+			case 8:
+				values[j] = float64(int16(ifd.order.Uint16(v)))
+			case 4:
+				values[j] = float64(ifd.order.Uint32(v))
+			case 9:
+				values[j] = float64(int32(ifd.order.Uint32(v)))
+			case 11:
+				values[j] = float64(math.Float32frombits(ifd.order.Uint32(v)))
+			case 12:
+				values[j] = math.Float64frombits(ifd.order.Uint64(v))
+			case 5:
+				values[j] = float64(ifd.order.Uint32(v)) / float64(ifd.order.Uint32(v[4:]))
+			case 10:
+				values[j] = float64(int32(ifd.order.Uint32(v))) / float64(int32(ifd.order.Uint32(v[4:])))
+			}
+		}
+		ifd.tags[tag] = values
+	}
+
+	return &ifd, nil
+}
+
+// geoKeys returns the GeoKeys with short values from the GeoKeyDirectoryTag
+func (ifd *tiffIFD) geoKeys() map[int]int {
+	keys := map[int]int{}
+	dir := ifd.tags[geoTIFFGeoKeyDirectory]
+	if len(dir) < 4 {
+		return keys
+	}
+
+	for i := 0; i < int(dir[3]) && 4+i*4+3 < len(dir); i++ {
+		entry := dir[4+i*4:]
+		// Location 0 means the value is stored in the entry itself
+		if entry[1] == 0 {
+			keys[int(entry[0])] = int(entry[3])
+		}
+	}
+	return keys
+}
+
+// utmZoneFromEPSG returns the UTM zone for northern hemisphere ETRS89 and WGS 84 UTM codes
+func utmZoneFromEPSG(code int) (int, error) {
+	switch {
+	case code > 25800 && code <= 25860:
+		return code - 25800, nil
+	case code > 32600 && code <= 32660:
+		return code - 32600, nil
+	}
+	return 0, fmt.Errorf("unsupported coordinate reference system EPSG:%d", code)
+}
+
+// decompress returns the uncompressed contents of a strip or tile
+func (ifd *tiffIFD) decompress(compressed []byte) ([]byte, error) {
+	switch c := ifd.intValue(tiffCompression, tiffCompressionNone); c {
+	case tiffCompressionNone:
+		return compressed, nil
+	case tiffCompressionLZW:
+		return decodeTIFFLZW(compressed)
+	case tiffCompressionDeflate, tiffCompressionAdobeDeflate:
+		r, err := zlib.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("unsupported compression %d", c)
+	}
+}
+
+// decodeBlock converts the uncompressed data of a strip or tile with the given width to elevations. The elevations
+// are written to out, which is reallocated if it is too small.
+func (ifd *tiffIFD) decodeBlock(data []byte, width int, rows int, out []float32) ([]float32, error) {
+	sampleFormat := ifd.intValue(tiffSampleFormat, 1)
+	bitsPerSample := ifd.intValue(tiffBitsPerSample, 1)
+	bytesPerSample := bitsPerSample / 8
+
+	// The size is checked by division, since the product of huge block sizes may overflow
+	if bytesPerSample <= 0 || len(data)/bytesPerSample/rows < width {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if len(out) < width*rows {
+		out = make([]float32, width*rows)
+	}
+
+	order := ifd.order
+	switch predictor := ifd.intValue(tiffPredictor, tiffPredictorNone); predictor {
+	case tiffPredictorNone:
+	case tiffPredictorHorizontal:
+		if bytesPerSample != 2 {
+			return nil, fmt.Errorf("unsupported predictor %d for %d bit samples", predictor, bitsPerSample)
+		}
+		for row := 0; row < rows; row++ {
+			line := data[row*width*2 : (row+1)*width*2]
+			for i := 2; i < len(line); i += 2 {
+				order.PutUint16(line[i:], order.Uint16(line[i:])+order.Uint16(line[i-2:]))
+			}
+		}
+	case tiffPredictorFloatingPoint:
+		// Bytes are differenced and split into one plane per byte, with the most significant byte first
+		tmp := make([]byte, width*bytesPerSample)
+		for row := 0; row < rows; row++ {
+			line := data[row*width*bytesPerSample : (row+1)*width*bytesPerSample]
+			for i := 1; i < len(line); i++ {
+				line[i] += line[i-1]
+			}
+			copy(tmp, line)
+			for i := 0; i < width; i++ {
+				for b := 0; b < bytesPerSample; b++ {
+					line[i*bytesPerSample+b] = tmp[b*width+i]
+				}
+			}
+		}
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("unsupported predictor %d", predictor)
+	}
+
+	switch {
+	case sampleFormat == tiffSampleFormatFloat && bitsPerSample == 32:
+		for i := 0; i < width*rows; i++ {
+			out[i] = math.Float32frombits(order.Uint32(data[i*4:]))
+		}
+	case sampleFormat == tiffSampleFormatInt && bitsPerSample == 16:
+		for i := 0; i < width*rows; i++ {
+			out[i] = float32(int16(order.Uint16(data[i*2:])))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sample format %d with %d bits", sampleFormat, bitsPerSample)
+	}
+	return out, nil
+}
+
+// readBlocks reads all strips or tiles of the image into the elevations of r
+func (ifd *tiffIFD) readBlocks(r *utmRaster) error {
+	blockWidth := ifd.intValue(tiffTileWidth, r.cols)
+	blockRows := ifd.intValue(tiffTileLength, ifd.intValue(tiffRowsPerStrip, r.rows))
+	if blockWidth <= 0 || blockRows <= 0 {
+		return fmt.Errorf("invalid strip or tile size %dx%d", blockWidth, blockRows)
+	}
+
+	// Rows beyond the image are not read. The default RowsPerStrip is 2^32-1, which is a single strip.
+	if blockRows > r.rows {
+		blockRows = r.rows
+	}
+	offsets, counts := ifd.tags[tiffTileOffsets], ifd.tags[tiffTileByteCounts]
+	if offsets == nil {
+		offsets, counts = ifd.tags[tiffStripOffsets], ifd.tags[tiffStripByteCounts]
+	}
+
+	blocksAcross := (r.cols + blockWidth - 1) / blockWidth
+	blocksDown := (r.rows + blockRows - 1) / blockRows
+	if len(offsets) < blocksAcross*blocksDown || len(counts) < len(offsets) {
+		return fmt.Errorf("missing strip or tile offsets")
+	}
+
+	var block []float32
+	for i := 0; i < blocksAcross*blocksDown; i++ {
+		offset, count := int(offsets[i]), int(counts[i])
+		if offset+count > len(ifd.data) {
+			return fmt.Errorf("block %d is outside the file", i)
+		}
+
+		data, err := ifd.decompress(ifd.data[offset : offset+count])
+		if err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+
+		// The last strip may have fewer rows than the others
+		row0, col0 := (i/blocksAcross)*blockRows, (i%blocksAcross)*blockWidth
+		rows := blockRows
+		if ifd.tags[tiffTileOffsets] == nil && row0+rows > r.rows {
+			rows = r.rows - row0
+		}
+
+		if block, err = ifd.decodeBlock(data, blockWidth, rows, block); err != nil {
+			return fmt.Errorf("block %d: %w", i, err)
+		}
+
+		// Tiles may extend beyond the image
+		for row := 0; row < rows && row0+row < r.rows; row++ {
+			n := blockWidth
+			if col0+n > r.cols {
+				n = r.cols - col0
+			}
+			copy(r.elevations[(row0+row)*r.cols+col0:], block[row*blockWidth:row*blockWidth+n])
+		}
+	}
+	return nil
+}
+
+// readGeoTIFF reads a single band GeoTIFF with a projected UTM coordinate reference system
+func readGeoTIFF(data []byte) (*utmRaster, error) {
+	ifd, r, err := readGeoTIFFHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r.elevations = make([]float32, r.cols*r.rows)
+	if err = ifd.readBlocks(r); err != nil {
+		return nil, err
+	}
+
+	noData, hasNoData := ifd.tags[gdalNoData]
+	for i, v := range r.elevations {
+		if math.IsNaN(float64(v)) || hasNoData && v == float32(noData[0]) {
+			r.elevations[i] = voidElevation
+		}
+	}
+	return r, nil
+}
+
+// readGeoTIFFHeader reads the image file directory and returns a utmRaster without elevations
+func readGeoTIFFHeader(data []byte) (*tiffIFD, *utmRaster, error) {
+	ifd, err := readTIFFIFD(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ifd.intValue(tiffSamplesPerPixel, 1) != 1 {
+		return nil, nil, fmt.Errorf("unsupported number of samples per pixel %d", ifd.intValue(tiffSamplesPerPixel, 1))
+	}
+
+	keys := ifd.geoKeys()
+	zone, err := utmZoneFromEPSG(keys[geoKeyProjectedCSType])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if unit, ok := keys[geoKeyProjLinearUnits]; ok && unit != geoKeyLinearUnitMeter {
+		return nil, nil, fmt.Errorf("unsupported linear unit %d", unit)
+	}
+
+	scale, tiepoint := ifd.tags[geoTIFFModelPixelScale], ifd.tags[geoTIFFModelTiepoint]
+	if len(scale) < 2 || len(tiepoint) < 6 {
+		return nil, nil, fmt.Errorf("missing pixel scale or tiepoint")
+	}
+	if scale[0] != scale[1] || scale[0] <= 0 {
+		return nil, nil, fmt.Errorf("unsupported pixel scale %vx%v", scale[0], scale[1])
+	}
+
+	r := utmRaster{
+		zone:       zone,
+		resolution: scale[0],
+		easting0:   tiepoint[3] - tiepoint[0]*scale[0],
+		northing0:  tiepoint[4] + tiepoint[1]*scale[1],
+		cols:       ifd.intValue(tiffImageWidth, 0),
+		rows:       ifd.intValue(tiffImageLength, 0),
+	}
+
+	// Points are at the pixel centers when the tiepoint refers to the pixel corner
+	if keys[geoKeyRasterType] == geoKeyRasterPixelIsArea || keys[geoKeyRasterType] == 0 {
+		r.easting0 += r.resolution / 2
+		r.northing0 -= r.resolution / 2
+	}
+
+	if r.cols <= 0 || r.rows <= 0 {
+		return nil, nil, fmt.Errorf("invalid image size %d x %d", r.cols, r.rows)
+	}
+
+	return ifd, &r, nil
+}
@@ -0,0 +1,302 @@
+package dataset
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// encodeTIFFLZW compresses data with TIFF LZW
+func encodeTIFFLZW(data []byte) []byte {
+	var out bytes.Buffer
+	var bits uint32
+	var nBits int
+	width := 9
+	write := func(code int) {
+		bits = bits<<uint(width) | uint32(code)
+		nBits += width
+		for nBits >= 8 {
+			out.WriteByte(byte(bits >> uint(nBits-8)))
+			nBits -= 8
+		}
+	}
+
+	table := map[string]int{}
+	next := lzwEndOfInput + 1
+	write(lzwClear)
+	var prefix []byte
+	for _, b := range data {
+		s := string(append(prefix, b))
+		if _, ok := table[s]; ok || len(s) == 1 {
+			prefix = []byte(s)
+			continue
+		}
+		if len(prefix) == 1 {
+			write(int(prefix[0]))
+		} else {
+			write(table[string(prefix)])
+		}
+		table[s] = next
+		next++
+		if next > 1<<uint(width)-1 {
+			width++
+		}
+		if next == 1<<lzwMaxWidth-2 {
+			write(lzwClear)
+			table, next, width = map[string]int{}, lzwEndOfInput+1, 9
+		}
+		prefix = []byte{b}
+	}
+	if len(prefix) == 1 {
+		write(int(prefix[0]))
+	} else if len(prefix) > 1 {
+		write(table[string(prefix)])
+	}
+	write(lzwEndOfInput)
+	if nBits > 0 {
+		out.WriteByte(byte(bits << uint(8-nBits)))
+	}
+	return out.Bytes()
+}
+
+func TestTIFFLZW(t *testing.T) {
+	var data []byte
+	for i := 0; i < 20000; i++ {
+		data = append(data, byte(i*i/1000))
+	}
+
+	got, err := decodeTIFFLZW(encodeTIFFLZW(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("decoded %d bytes that differ from the %d input bytes", len(got), len(data))
+	}
+}
+
+type tiffEntry struct {
+	tag    uint16
+	typ    uint16
+	values []float64
+}
+
+// encode returns the values of the entry. ASCII entries hold a single number.
+func (e tiffEntry) encode(order binary.ByteOrder) []byte {
+	var v bytes.Buffer
+	for _, f := range e.values {
+		switch e.typ {
+		case 2:
+			v.WriteString(strconv.FormatFloat(f, 'f', -1, 64) + "\x00")
+		case 3:
+			binary.Write(&v, order, uint16(f))
+		case 4:
+			binary.Write(&v, order, uint32(f))
+		case 12:
+			binary.Write(&v, order, f)
+		}
+	}
+	return v.Bytes()
+}
+
+// writeGeoTIFF writes a little endian tiff with the given blocks (strips or tiles) and tags
+func writeGeoTIFF(entries []tiffEntry, blocks [][]byte) []byte {
+	order := binary.LittleEndian
+	offsetsTag, countsTag := uint16(tiffStripOffsets), uint16(tiffStripByteCounts)
+	for _, e := range entries {
+		if e.tag == tiffTileWidth {
+			offsetsTag, countsTag = tiffTileOffsets, tiffTileByteCounts
+		}
+	}
+	offsets := make([]float64, len(blocks))
+	counts := make([]float64, len(blocks))
+	entries = append(entries, tiffEntry{offsetsTag, 4, offsets}, tiffEntry{countsTag, 4, counts})
+
+	// The directory is followed by the values that do not fit in the entries, and then the blocks
+	valuesOffset := 8 + 2 + len(entries)*12 + 4
+	blockOffset := valuesOffset
+	for _, e := range entries {
+		if n := len(e.encode(order)); n > 4 {
+			blockOffset += n
+		}
+	}
+	for i, b := range blocks {
+		offsets[i] = float64(blockOffset)
+		counts[i] = float64(len(b))
+		blockOffset += len(b)
+	}
+
+	var buf, values bytes.Buffer
+	buf.WriteString("II")
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8))
+	binary.Write(&buf, order, uint16(len(entries)))
+	for _, e := range entries {
+		v := e.encode(order)
+		binary.Write(&buf, order, e.tag)
+		binary.Write(&buf, order, e.typ)
+		binary.Write(&buf, order, uint32(len(v)/tiffTypeSize(e.typ)))
+		if len(v) <= 4 {
+			buf.Write(append(v, 0, 0, 0, 0)[:4])
+		} else {
+			binary.Write(&buf, order, uint32(valuesOffset+values.Len()))
+			values.Write(v)
+		}
+	}
+	binary.Write(&buf, order, uint32(0))
+
+	buf.Write(values.Bytes())
+	for _, b := range blocks {
+		buf.Write(b)
+	}
+	return buf.Bytes()
+}
+
+func float32Bytes(values []float32) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, values)
+	return buf.Bytes()
+}
+
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestReadGeoTIFF(t *testing.T) {
+	const size = 20
+	elevation := func(col, row int) float32 { return float32(col) + 100*float32(row) + 0.5 }
+	geoTags := []tiffEntry{
+		{tiffImageWidth, 3, []float64{size}},
+		{tiffImageLength, 3, []float64{size}},
+		{tiffBitsPerSample, 3, []float64{32}},
+		{tiffSampleFormat, 3, []float64{tiffSampleFormatFloat}},
+		{geoTIFFModelPixelScale, 12, []float64{10, 10, 0}},
+		{geoTIFFModelTiepoint, 12, []float64{0, 0, 0, 400000, 6850000, 0}},
+		{geoTIFFGeoKeyDirectory, 3, []float64{1, 1, 0, 2,
+			geoKeyRasterType, 0, 1, geoKeyRasterPixelIsArea,
+			geoKeyProjectedCSType, 0, 1, 25832}},
+	}
+
+	strips := func(rowsPerStrip int, compress func([]byte) []byte) [][]byte {
+		var blocks [][]byte
+		for row0 := 0; row0 < size; row0 += rowsPerStrip {
+			var values []float32
+			for row := row0; row < row0+rowsPerStrip && row < size; row++ {
+				for col := 0; col < size; col++ {
+					values = append(values, elevation(col, row))
+				}
+			}
+			blocks = append(blocks, compress(float32Bytes(values)))
+		}
+		return blocks
+	}
+
+	tiles := func(tileSize int, compress func([]byte) []byte) [][]byte {
+		var blocks [][]byte
+		for row0 := 0; row0 < size; row0 += tileSize {
+			for col0 := 0; col0 < size; col0 += tileSize {
+				var values []float32
+				for row := row0; row < row0+tileSize; row++ {
+					for col := col0; col < col0+tileSize; col++ {
+						values = append(values, elevation(col, row))
+					}
+				}
+				blocks = append(blocks, compress(float32Bytes(values)))
+			}
+		}
+		return blocks
+	}
+
+	none := func(b []byte) []byte { return b }
+
+	for name, tc := range map[string]struct {
+		tags   []tiffEntry
+		blocks [][]byte
+	}{
+		"strips": {
+			tags:   []tiffEntry{{tiffRowsPerStrip, 3, []float64{7}}},
+			blocks: strips(7, none),
+		},
+		"default rows per strip": {
+			tags:   []tiffEntry{{tiffRowsPerStrip, 4, []float64{math.MaxUint32}}},
+			blocks: strips(size, none),
+		},
+		"deflate strips": {
+			tags: []tiffEntry{
+				{tiffRowsPerStrip, 3, []float64{3}},
+				{tiffCompression, 3, []float64{tiffCompressionDeflate}}},
+			blocks: strips(3, deflate),
+		},
+		"lzw tiles": {
+			tags: []tiffEntry{
+				{tiffTileWidth, 3, []float64{16}},
+				{tiffTileLength, 3, []float64{16}},
+				{tiffCompression, 3, []float64{tiffCompressionLZW}}},
+			blocks: tiles(16, encodeTIFFLZW),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r, err := readGeoTIFF(writeGeoTIFF(append(append([]tiffEntry{}, geoTags...), tc.tags...), tc.blocks))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.zone != 32 || r.resolution != 10 || r.easting0 != 400005 || r.northing0 != 6849995 {
+				t.Fatalf("unexpected georeference %+v", *r)
+			}
+
+			for row := 0; row < size; row++ {
+				for col := 0; col < size; col++ {
+					if got := r.elevations[row*size+col]; got != elevation(col, row) {
+						t.Fatalf("elevation at %d, %d is %v, want %v", col, row, got, elevation(col, row))
+					}
+				}
+			}
+
+			buf, minEasting, maxNorthing, err := r.alignedBuffer(100, 10, 11)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Points are between the pixel centers
+			want := (elevation(2, 3) + elevation(3, 3) + elevation(2, 4) + elevation(3, 4)) / 4
+			if minEasting != 400000 || maxNorthing != 6850000 || math.Abs(float64(buf[4][3]-want)) > 1e-3 {
+				t.Errorf("aligned buffer at %v, %v has %v, want %v", minEasting, maxNorthing, buf[4][3], want)
+			}
+		})
+	}
+
+	for name, tags := range map[string][]tiffEntry{
+		"zero rows per strip": {{tiffRowsPerStrip, 3, []float64{0}}},
+		"zero tile width": {
+			{tiffTileWidth, 3, []float64{0}},
+			{tiffTileLength, 3, []float64{16}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tags = append(append([]tiffEntry{}, geoTags...), tags...)
+			if _, err := readGeoTIFF(writeGeoTIFF(tags, strips(size, none))); err == nil {
+				t.Errorf("no error for invalid block size")
+			}
+		})
+	}
+
+	t.Run("nodata", func(t *testing.T) {
+		values := make([]float32, size*size)
+		values[1] = -9999
+		values[2] = float32(math.NaN())
+		tags := append(append([]tiffEntry{}, geoTags...), tiffEntry{gdalNoData, 2, []float64{-9999}})
+		r, err := readGeoTIFF(writeGeoTIFF(tags, [][]byte{float32Bytes(values)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.elevations[0] != 0 || r.elevations[1] != voidElevation || r.elevations[2] != voidElevation {
+			t.Errorf("unexpected elevations %v", r.elevations[:3])
+		}
+	})
+}
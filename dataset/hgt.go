@@ -0,0 +1,312 @@
+package dataset
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hgtVoid is the elevation value used for points without data in hgt files
+const hgtVoid = -32768
+
+// hgtName matches hgt file names like N61E008.hgt, which is named after the south west corner
+var hgtName = regexp.MustCompile(`^([NSns])(\d{2})([EWew])(\d{3})`)
+
+// HGT reads SRTM and Copernicus .hgt files with elevation data on a geographic lat/lng grid
+// and resamples the data onto 50x50 km tiles in the UTM zone for the center of each file.
+type HGT struct{}
+
+// hgtFile is the contents of one hgt file. It covers one degree of latitude and longitude.
+type hgtFile struct {
+	// rows and cols is the number of points in each direction. SRTM files have 3601 or 1201 rows, and the same number
+	// of columns. Copernicus files have 3600 or 1200 rows, and fewer columns north of 50 degrees, see hgtColumns.
+	rows, cols int
+
+	// edges is true if the last row and column are on the southern and eastern edges, like the first row and column
+	// are on the northern and western edges. Copernicus files have no points on the southern and eastern edges.
+	edges bool
+
+	elevations []int16
+}
+
+// hgtColumns returns the number of columns of Copernicus files at the given latitude band, which gives about the same
+// spacing in meters in both directions
+func hgtColumns(rows int, lat int) int {
+	// The band is given by the latitude of the edge closest to the equator
+	if lat < 0 {
+		lat = -lat - 1
+	}
+
+	switch {
+	case lat < 50:
+		return rows
+	case lat < 60:
+		return rows * 2 / 3
+	case lat < 70:
+		return rows / 2
+	case lat < 80:
+		return rows / 3
+	case lat < 85:
+		return rows / 5
+	default:
+		return rows / 10
+	}
+}
+
+// hgtCell returns the latitude/longitude of the south west corner of the given hgt file
+func hgtCell(fname string) (lat int, lng int, err error) {
+	m := hgtName.FindStringSubmatch(filepath.Base(fname))
+	if m == nil {
+		return 0, 0, fmt.Errorf("unexpected hgt file name %s", fname)
+	}
+
+	lat, _ = strconv.Atoi(m[2])
+	lng, _ = strconv.Atoi(m[4])
+	if strings.EqualFold(m[1], "S") {
+		lat = -lat
+	}
+	if strings.EqualFold(m[3], "W") {
+		lng = -lng
+	}
+	return lat, lng, nil
+}
+
+// hgtFileName returns the name of the hgt file for the given cell, formatted like the given file name
+func hgtFileName(like string, lat int, lng int) string {
+	ns, ew := "N", "E"
+	if lat < 0 {
+		ns, lat = "S", -lat
+	}
+	if lng < 0 {
+		ew, lng = "W", -lng
+	}
+
+	base := filepath.Base(like)
+	name := fmt.Sprintf("%s%02d%s%03d", ns, lat, ew, lng)
+	if hgtName.FindString(base) != strings.ToUpper(hgtName.FindString(base)) {
+		name = strings.ToLower(name)
+	}
+	return filepath.Join(filepath.Dir(like), name+base[len(name):])
+}
+
+// readHGTFile reads the given hgt file. The size of the grid is given by the size of the file, which is square for
+// SRTM files, and has fewer columns than rows for Copernicus files north of 50 degrees.
+func readHGTFile(fname string) (*hgtFile, error) {
+	lat, _, err := hgtCell(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	f := hgtSize(len(data)/2, lat)
+	if f == nil || f.rows*f.cols*2 != len(data) {
+		return nil, fmt.Errorf("unexpected hgt file size %d at latitude %d", len(data), lat)
+	}
+
+	f.elevations = make([]int16, f.rows*f.cols)
+	for i := range f.elevations {
+		f.elevations[i] = int16(binary.BigEndian.Uint16(data[i*2:]))
+	}
+	return f, nil
+}
+
+// hgtSize returns an hgtFile without elevations for a file with the given number of points at the given latitude.
+// It returns nil if there is no such file.
+func hgtSize(points int, lat int) *hgtFile {
+	for _, rows := range []int{3601, 1201, 3600, 1200} {
+		edges := rows%2 == 1
+		cols := hgtColumns(rows, lat)
+		if edges {
+			cols = hgtColumns(rows-1, lat) + 1
+		}
+		if rows*rows == points || rows*cols == points {
+			return &hgtFile{rows: rows, cols: points / rows, edges: edges}
+		}
+	}
+
+	// Other square files are also accepted, like the small files in tests
+	if size := int(math.Round(math.Sqrt(float64(points)))); size >= 2 && size*size == points {
+		return &hgtFile{rows: size, cols: size, edges: true}
+	}
+	return nil
+}
+
+// hgtMosaic gives access to the hgt files in a directory, which are loaded when needed
+type hgtMosaic struct {
+	like  string
+	files map[[2]int]*hgtFile
+}
+
+// sample returns the elevation at the given position using bilinear interpolation
+func (m *hgtMosaic) sample(lat float64, lng float64) float32 {
+	cellLat, cellLng := int(math.Floor(lat)), int(math.Floor(lng))
+	cell := [2]int{cellLat, cellLng}
+	f, ok := m.files[cell]
+	if !ok {
+		var err error
+		f, err = readHGTFile(hgtFileName(m.like, cellLat, cellLng))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("skipping hgt file: %v", err)
+		}
+		m.files[cell] = f
+	}
+	if f == nil {
+		return voidElevation
+	}
+
+	// The first row is the northern edge. The southern and eastern edges are either shared with the neighbour files,
+	// or the last point is used up to the edge.
+	latSteps, lngSteps := f.rows, f.cols
+	if f.edges {
+		latSteps, lngSteps = f.rows-1, f.cols-1
+	}
+	x := (lng - float64(cellLng)) * float64(lngSteps)
+	y := (float64(cellLat) + 1 - lat) * float64(latSteps)
+	col, row := int(x), int(y)
+	if col >= f.cols-1 {
+		col = f.cols - 2
+	}
+	if row >= f.rows-1 {
+		row = f.rows - 2
+	}
+	fx, fy := math.Min(x-float64(col), 1), math.Min(y-float64(row), 1)
+
+	e00 := f.elevations[row*f.cols+col]
+	e01 := f.elevations[row*f.cols+col+1]
+	e10 := f.elevations[(row+1)*f.cols+col]
+	e11 := f.elevations[(row+1)*f.cols+col+1]
+	if e00 == hgtVoid || e01 == hgtVoid || e10 == hgtVoid || e11 == hgtVoid {
+		return voidElevation
+	}
+
+	return float32((float64(e00)*(1-fx)+float64(e01)*fx)*(1-fy) +
+		(float64(e10)*(1-fx)+float64(e11)*fx)*fy)
+}
+
+// Tiles returns the position of the tiles with their center inside the given hgt file. This makes each tile
+// belong to exactly one hgt file. Parts of a tile that are outside the file are read from the neighbour files.
+func (h *HGT) Tiles(fname string) (tiles []TilePosition, err error) {
+	lat, lng, err := hgtCell(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	zone := utmZone(float64(lat)+0.5, float64(lng)+0.5)
+	tm := newTransverseMercator(zoneCentralMeridian(zone))
+
+	// The edges of the cell are curved in UTM coordinates, so the midpoints are included in the bounding box
+	minEasting, maxEasting := math.Inf(1), math.Inf(-1)
+	minNorthing, maxNorthing := math.Inf(1), math.Inf(-1)
+	for _, p := range [][2]float64{{0, 0}, {0, 0.5}, {0, 1}, {0.5, 0}, {0.5, 1}, {1, 0}, {1, 0.5}, {1, 1}} {
+		e, n := tm.forward(float64(lat)+p[0], float64(lng)+p[1])
+		minEasting, maxEasting = math.Min(minEasting, e), math.Max(maxEasting, e)
+		minNorthing, maxNorthing = math.Min(minNorthing, n), math.Max(maxNorthing, n)
+	}
+
+	for e := math.Floor(minEasting/tileSize) * tileSize; e < maxEasting; e += tileSize {
+		for n := math.Ceil(maxNorthing/tileSize) * tileSize; n > minNorthing; n -= tileSize {
+			centerLat, centerLng := tm.inverse(e+tileSize/2, n-tileSize/2)
+			if int(math.Floor(centerLat)) == lat && int(math.Floor(centerLng)) == lng {
+				tiles = append(tiles, TilePosition{Zone: zone, MinEasting: e, MaxNorthing: n, Spacing: Unit})
+			}
+		}
+	}
+	return tiles, nil
+}
+
+// Sources returns the neighbour hgt files that ReadTile reads for the parts of the tile outside the given file
+func (h *HGT) Sources(fname string, tile TilePosition) ([]string, error) {
+	lat, lng, err := hgtCell(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	// The edges of the tile are curved in lat/lng, so the midpoints are included in the bounding box
+	tm := newTransverseMercator(zoneCentralMeridian(tile.Zone))
+	minLat, maxLat := math.Inf(1), math.Inf(-1)
+	minLng, maxLng := math.Inf(1), math.Inf(-1)
+	for _, p := range [][2]float64{{0, 0}, {0, 0.5}, {0, 1}, {0.5, 0}, {0.5, 1}, {1, 0}, {1, 0.5}, {1, 1}} {
+		pointLat, pointLng := tm.inverse(tile.MinEasting+p[0]*tile.size(), tile.MaxNorthing-p[1]*tile.size())
+		minLat, maxLat = math.Min(minLat, pointLat), math.Max(maxLat, pointLat)
+		minLng, maxLng = math.Min(minLng, pointLng), math.Max(maxLng, pointLng)
+	}
+
+	var sources []string
+	for cellLat := int(math.Floor(minLat)); cellLat <= int(math.Floor(maxLat)); cellLat++ {
+		for cellLng := int(math.Floor(minLng)); cellLng <= int(math.Floor(maxLng)); cellLng++ {
+			if cellLat != lat || cellLng != lng {
+				sources = append(sources, hgtFileName(fname, cellLat, cellLng))
+			}
+		}
+	}
+	return sources, nil
+}
+
+// ReadTile resamples the given tile from the hgt file and its neighbours in the same directory.
+// Neighbours that can't be read are treated as void.
+func (h *HGT) ReadTile(fname string, tile TilePosition) ([][]float32, error) {
+	log.Printf("reading %s for tile %v", fname, tile)
+	lat, lng, err := hgtCell(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := readHGTFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	tm := newTransverseMercator(zoneCentralMeridian(tile.Zone))
+	mosaic := hgtMosaic{
+		like:  fname,
+		files: map[[2]int]*hgtFile{{lat, lng}: f},
+	}
+
+	// Lat/lng is computed for every 50th point and interpolated in between, which is far more accurate than
+	// the elevation data and much faster than projecting every point.
+	const step = 50
+	const points = bigSquareSize/step + 1
+	var lats, lngs [points][points]float64
+	for i := 0; i < points; i++ {
+		for j := 0; j < points; j++ {
+			lats[i][j], lngs[i][j] = tm.inverse(tile.MinEasting+float64(j*step)*tile.Spacing, tile.MaxNorthing-float64(i*step)*tile.Spacing)
+		}
+	}
+
+	buffer := make([][]float32, bigSquareSize+1)
+	for row := range buffer {
+		buffer[row] = make([]float32, bigSquareSize+1)
+		i, fi := row/step, float64(row%step)/step
+		if i == points-1 {
+			i, fi = points-2, 1
+		}
+		for col := range buffer[row] {
+			j, fj := col/step, float64(col%step)/step
+			if j == points-1 {
+				j, fj = points-2, 1
+			}
+
+			lat := (lats[i][j]*(1-fj)+lats[i][j+1]*fj)*(1-fi) + (lats[i+1][j]*(1-fj)+lats[i+1][j+1]*fj)*fi
+			lng := (lngs[i][j]*(1-fj)+lngs[i][j+1]*fj)*(1-fi) + (lngs[i+1][j]*(1-fj)+lngs[i+1][j+1]*fj)*fi
+			buffer[row][col] = mosaic.sample(lat, lng)
+		}
+	}
+	return buffer, nil
+}
+
+// ReadFile is not supported, since each hgt file is resampled to several tiles. Use Tiles and ReadTile.
+func (h *HGT) ReadFile(fname string) (buffer [][]float32, tile TilePosition, err error) {
+	return nil, tile, errors.New("hgt files must be read using ReadTile")
+}
@@ -0,0 +1,181 @@
+package dataset
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHGTTiles(t *testing.T) {
+	h := HGT{}
+
+	// Each tile is produced from exactly one hgt file
+	seen := map[TilePosition]string{}
+	for lat := 59; lat <= 61; lat++ {
+		for lng := 7; lng <= 10; lng++ {
+			fname := hgtFileName("N00E000.hgt", lat, lng)
+			tiles, err := h.Tiles(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, tile := range tiles {
+				if other, ok := seen[tile]; ok {
+					t.Errorf("tile %v is produced from both %s and %s", tile, other, fname)
+				}
+				seen[tile] = fname
+			}
+		}
+	}
+
+	// There are no gaps between the tiles well inside the files
+	for e := 450_000.0; e <= 550_000; e += tileSize {
+		for n := 6_700_000.0; n <= 6_800_000; n += tileSize {
+			if _, ok := seen[TilePosition{32, e, n, Unit}]; !ok {
+				t.Errorf("no hgt file produces tile %v, %v", e, n)
+			}
+		}
+	}
+}
+
+func TestHGTSources(t *testing.T) {
+	h := HGT{}
+	fname := filepath.Join("dem", "N61E008.hgt")
+	tiles, err := h.Tiles(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Some tiles are partly in the neighbour files, which are all named like the file
+	neighbours := map[string]bool{}
+	for _, tile := range tiles {
+		sources, err := h.Sources(fname, tile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, source := range sources {
+			if source == fname || filepath.Dir(source) != "dem" {
+				t.Errorf("unexpected source %s for tile %v", source, tile)
+			}
+			neighbours[filepath.Base(source)] = true
+		}
+	}
+	for _, name := range []string{"N60E008.hgt", "N61E009.hgt"} {
+		if !neighbours[name] {
+			t.Errorf("%s is not a source of any tile, got %v", name, neighbours)
+		}
+	}
+}
+
+func TestHGTSample(t *testing.T) {
+	const size = 11
+	dir, err := ioutil.TempDir("", "hgt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Elevation is 100 times the number of points from the south west corner along each axis
+	data := make([]byte, size*size*2)
+	for row := 0; row < size; row++ {
+		for col := 0; col < size; col++ {
+			binary.BigEndian.PutUint16(data[(row*size+col)*2:], uint16(100*(size-1-row)+100*col))
+		}
+	}
+	binary.BigEndian.PutUint16(data[0:], uint16(0x8000))
+	if err = ioutil.WriteFile(filepath.Join(dir, "N61E008.hgt"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := hgtMosaic{like: filepath.Join(dir, "N00E000.hgt"), files: map[[2]int]*hgtFile{}}
+	for _, tc := range []struct {
+		lat, lng float64
+		want     float32
+	}{
+		{61, 8, 0},
+		{61.5, 8.5, 1000},
+		{61.25, 8.05, 300},
+		{61.95, 8.05, voidElevation},
+		{60.5, 8.5, voidElevation},
+	} {
+		if got := m.sample(tc.lat, tc.lng); math.Abs(float64(got-tc.want)) > 1e-3 {
+			t.Errorf("sample(%v, %v) = %v, want %v", tc.lat, tc.lng, got, tc.want)
+		}
+	}
+}
+
+func TestHGTCopernicus(t *testing.T) {
+	// Copernicus files have half as many columns as rows from 60 to 70 degrees, and no points on the southern and
+	// eastern edges
+	const rows, cols = 1200, 600
+	dir, err := ioutil.TempDir("", "hgt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Elevation is the number of points from the southern edge plus twice the number of points from the western edge
+	data := make([]byte, rows*cols*2)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			binary.BigEndian.PutUint16(data[(row*cols+col)*2:], uint16(rows-row+2*col))
+		}
+	}
+	for _, name := range []string{"N61E008.hgt", "N45E008.hgt"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := readHGTFile(filepath.Join(dir, "N61E008.hgt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.rows != rows || f.cols != cols || f.edges {
+		t.Errorf("hgt file is %dx%d with edges %v", f.rows, f.cols, f.edges)
+	}
+
+	// South of 50 degrees, the files are square
+	if _, err = readHGTFile(filepath.Join(dir, "N45E008.hgt")); err == nil {
+		t.Errorf("no error for a file with too few columns")
+	}
+
+	m := hgtMosaic{like: filepath.Join(dir, "N00E000.hgt"), files: map[[2]int]*hgtFile{}}
+	for _, tc := range []struct {
+		lat, lng float64
+		want     float32
+	}{
+		{61.9, 8, rows - 120},
+		{61.5, 8.5, rows/2 + cols},
+		{61.75, 8.25, rows*3/4 + cols/2},
+		{61, 8.999, 1 + 2*(cols-1)},
+	} {
+		if got := m.sample(tc.lat, tc.lng); math.Abs(float64(got-tc.want)) > 1e-3 {
+			t.Errorf("sample(%v, %v) = %v, want %v", tc.lat, tc.lng, got, tc.want)
+		}
+	}
+}
+
+func TestHGTSize(t *testing.T) {
+	for _, tc := range []struct {
+		rows, cols, lat int
+		edges           bool
+	}{
+		{3601, 3601, 45, true},
+		{3601, 2401, 55, true},
+		{3601, 1801, 65, true},
+		{1201, 401, 75, true},
+		{3600, 3600, 10, false},
+		{3600, 2400, -51, false},
+		{3600, 1200, 79, false},
+		{3600, 720, 80, false},
+		{1200, 120, 89, false},
+	} {
+		f := hgtSize(tc.rows*tc.cols, tc.lat)
+		if f == nil || f.rows != tc.rows || f.cols != tc.cols || f.edges != tc.edges {
+			t.Errorf("hgtSize(%d, %d) = %+v, want %dx%d", tc.rows*tc.cols, tc.lat, f, tc.rows, tc.cols)
+		}
+	}
+}
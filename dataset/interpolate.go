@@ -0,0 +1,144 @@
+package dataset
+
+import (
+	"math"
+)
+
+// Interpolation selects how elevations are interpolated between the elevation points
+type Interpolation int
+
+const (
+	// Bilinear interpolates linearly between the 2x2 surrounding points in both directions
+	Bilinear Interpolation = iota + 1
+
+	// Bicubic interpolates with cubic convolution between the 4x4 surrounding points, which gives smoother slopes.
+	// It falls back to Bilinear where some of the points have no tile.
+	Bicubic
+)
+
+// ElevationAt returns the elevation at the given easting/northing in the zone of the ElevationMap, using bilinear
+// interpolation. It returns NaN if there is no elevation data at the position.
+func (em *ElevationMap) ElevationAt(easting float64, northing float64) float64 {
+	return em.InterpolatedElevationAt(easting, northing, Bilinear)
+}
+
+// InterpolatedElevationAt is like ElevationAt, using the given interpolation
+func (em *ElevationMap) InterpolatedElevationAt(easting float64, northing float64, interpolation Interpolation) float64 {
+	minEasting, maxNorthing := em.Offsets()
+	interpolator := em.Interpolator(interpolation)
+	elevation, noData, ok := interpolator.Interpolate((easting-minEasting)/em.Spacing(), (maxNorthing-northing)/em.Spacing())
+	if !ok || noData {
+		return math.NaN()
+	}
+	return elevation
+}
+
+// Interpolator interpolates elevations in an ElevationMap. It keeps the last maplet it used, so that nearby
+// positions are interpolated without looking up the maplet again.
+type Interpolator struct {
+	elevationMap  ElevationMap
+	interpolation Interpolation
+
+	mapletE, mapletN IntStep
+	maplet           *ElevationMaplet
+}
+
+// Interpolator returns an Interpolator for the ElevationMap using the given interpolation
+func (em *ElevationMap) Interpolator(interpolation Interpolation) *Interpolator {
+	return &Interpolator{
+		elevationMap:  *em,
+		interpolation: interpolation,
+		mapletE:       -1,
+		mapletN:       -1,
+	}
+}
+
+// Interpolate returns the elevation at the given position in the grid, which is like the IntStep indices, but with
+// fractions. Points without elevation data are at sea level, and noData is set if the nearest point has no elevation
+// data. It returns false if there is no tile for the points.
+func (ip *Interpolator) Interpolate(x float64, y float64) (elevation float64, noData bool, ok bool) {
+	e, n := IntStep(math.Floor(x)), IntStep(math.Floor(y))
+	fx, fy := x-float64(e), y-float64(n)
+
+	var points [4][4]float64
+	if ip.interpolation == Bicubic && ip.elevations(e-1, n-1, 4, &points, &noData, 1+IntStep(math.Round(fx)), 1+IntStep(math.Round(fy))) {
+		var rows [4]float64
+		for i := range rows {
+			rows[i] = cubic(points[i], fx)
+		}
+		return cubic(rows, fy), noData, true
+	}
+
+	if !ip.elevations(e, n, 2, &points, &noData, IntStep(math.Round(fx)), IntStep(math.Round(fy))) {
+		return 0, false, false
+	}
+	return (points[0][0]*(1-fx)+points[0][1]*fx)*(1-fy) +
+		(points[1][0]*(1-fx)+points[1][1]*fx)*fy, noData, true
+}
+
+// elevations reads size x size points from e/n into points, in meters. Points without elevation data are at sea
+// level, and noData is set if the point at nearestE/nearestN in points has no data. It returns false if some of the
+// points have no tile.
+func (ip *Interpolator) elevations(e IntStep, n IntStep, size IntStep, points *[4][4]float64, noData *bool, nearestE IntStep, nearestN IntStep) bool {
+	if e < 0 || n < 0 {
+		return false
+	}
+
+	// The points are usually in one maplet, and are then read from it directly
+	if e%ElevationMapletSize <= ElevationMapletSize-size && n%ElevationMapletSize <= ElevationMapletSize-size {
+		maplet := ip.lookup(e, n)
+		if maplet == nil {
+			return false
+		}
+		e0, n0 := e%ElevationMapletSize, n%ElevationMapletSize
+		for i := IntStep(0); i < size; i++ {
+			row := maplet[n0+i][e0 : e0+size]
+			for j, elevation := range row {
+				points[i][j] = ip.meters(elevation, i == nearestN && IntStep(j) == nearestE, noData)
+			}
+		}
+		return true
+	}
+
+	for i := IntStep(0); i < size; i++ {
+		for j := IntStep(0); j < size; j++ {
+			pointE, pointN := e+j, n+i
+			maplet := ip.lookup(pointE, pointN)
+			if maplet == nil {
+				return false
+			}
+
+			elevation := maplet[pointN%ElevationMapletSize][pointE%ElevationMapletSize]
+			points[i][j] = ip.meters(elevation, i == nearestN && j == nearestE, noData)
+		}
+	}
+	return true
+}
+
+// meters converts the elevation to meters, where points without elevation data are at sea level. noData is set for
+// the nearest point.
+func (ip *Interpolator) meters(elevation Elevation16, nearest bool, noData *bool) float64 {
+	if nearest {
+		*noData = elevation == NoData
+	}
+	if elevation == NoData {
+		return 0
+	}
+	return float64(elevation) * Elevation16Unit
+}
+
+// lookup returns the maplet with the point e/n, or nil if there is no tile
+func (ip *Interpolator) lookup(e IntStep, n IntStep) *ElevationMaplet {
+	if e/ElevationMapletSize != ip.mapletE || n/ElevationMapletSize != ip.mapletN {
+		ip.mapletE, ip.mapletN = e/ElevationMapletSize, n/ElevationMapletSize
+		ip.maplet = ip.elevationMap.LookupElevationMaplet(e, n)
+	}
+	return ip.maplet
+}
+
+// cubic interpolates between p[1] and p[2] with cubic convolution (Catmull-Rom), where t is between 0 and 1
+func cubic(p [4]float64, t float64) float64 {
+	return p[1] + 0.5*t*(p[2]-p[0]+
+		t*(2*p[0]-5*p[1]+4*p[2]-p[3]+
+			t*(3*(p[1]-p[2])+p[3]-p[0])))
+}
@@ -0,0 +1,103 @@
+package dataset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestElevationAt(t *testing.T) {
+	west := tileAt(32, 61, 9)
+	east := west
+	east.MinEasting += west.size()
+	r := testReader{
+		tiles: map[string]TilePosition{
+			"west.dem": west,
+			"east.dem": east,
+		},
+		elevation: func(lat, lng float64) float64 {
+			if lat > 61.2 {
+				return voidElevation
+			}
+			return 1000*(lat-60) + 100*lng
+		},
+	}
+
+	em, report, cleanup := loadTestFiles(t, &r)
+	defer cleanup()
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	utm := NewUTM(32)
+	for _, pos := range [][2]float64{
+		{east.MinEasting - 1234.5, east.MaxNorthing - 20_000},
+		{east.MinEasting - 3, east.MaxNorthing - 20_007},
+		{east.MinEasting + 5678.9, east.MaxNorthing - 43_210.1},
+	} {
+		easting, northing := pos[0], pos[1]
+		minEasting, maxNorthing := em.Offsets()
+		x, y := (easting-minEasting)/Unit, (maxNorthing-northing)/Unit
+		e, n := IntStep(math.Floor(x)), IntStep(math.Floor(y))
+		fx, fy := x-float64(e), y-float64(n)
+
+		// Bilinear is the weighted mean of the four points around
+		want := (em.Elevation(e, n)*(1-fx)+em.Elevation(e+1, n)*fx)*(1-fy) +
+			(em.Elevation(e, n+1)*(1-fx)+em.Elevation(e+1, n+1)*fx)*fy
+		if got := em.ElevationAt(easting, northing); math.Abs(got-want) > 1e-9 {
+			t.Errorf("ElevationAt(%v, %v) = %v, want %v", easting, northing, got, want)
+		}
+
+		// Both are close to the terrain, which is nearly linear
+		lat, lng := utm.UTMToLatLng(easting, northing)
+		for _, interpolation := range []Interpolation{Bilinear, Bicubic} {
+			got := em.InterpolatedElevationAt(easting, northing, interpolation)
+			if want := r.elevation(lat, lng); math.Abs(got-want) > 0.2 {
+				t.Errorf("interpolation %d at %v, %v is %v, want %v", interpolation, easting, northing, got, want)
+			}
+		}
+
+		// The grid points are exact
+		for _, interpolation := range []Interpolation{Bilinear, Bicubic} {
+			got := em.InterpolatedElevationAt(minEasting+float64(e)*Unit, maxNorthing-float64(n)*Unit, interpolation)
+			if want := em.Elevation(e, n); got != want {
+				t.Errorf("interpolation %d at grid point is %v, want %v", interpolation, got, want)
+			}
+		}
+	}
+
+	// Points without elevation data are NaN, but are at sea level when interpolating
+	minEasting, maxNorthing := em.Offsets()
+	easting, northing := utm.LatLngToUTM(61.3, 9)
+	if got := em.ElevationAt(easting, northing); !math.IsNaN(got) {
+		t.Errorf("elevation without data is %v", got)
+	}
+	x, y := (easting-minEasting)/Unit, (maxNorthing-northing)/Unit
+	if elevation, noData, ok := em.Interpolator(Bicubic).Interpolate(x, y); elevation != 0 || !noData || !ok {
+		t.Errorf("Interpolate without data = %v, %v, %v", elevation, noData, ok)
+	}
+
+	// Points outside the tiles are NaN
+	if got := em.ElevationAt(west.MinEasting-100, west.MaxNorthing-100); !math.IsNaN(got) {
+		t.Errorf("elevation outside the tiles is %v", got)
+	}
+	if _, _, ok := em.Interpolator(Bilinear).Interpolate(-1, -1); ok {
+		t.Errorf("Interpolate at negative position is ok")
+	}
+}
+
+func TestCubic(t *testing.T) {
+	for _, tc := range []struct {
+		p    [4]float64
+		t    float64
+		want float64
+	}{
+		{[4]float64{1, 2, 3, 4}, 0, 2},
+		{[4]float64{1, 2, 3, 4}, 1, 3},
+		{[4]float64{1, 2, 3, 4}, 0.25, 2.25},
+		{[4]float64{0, 1, 1, 0}, 0.5, 1.125},
+	} {
+		if got := cubic(tc.p, tc.t); math.Abs(got-tc.want) > 1e-12 {
+			t.Errorf("cubic(%v, %v) = %v, want %v", tc.p, tc.t, got, tc.want)
+		}
+	}
+}
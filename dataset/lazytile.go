@@ -0,0 +1,164 @@
+package dataset
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// lazyTile is a tile in the index of an ElevationMap. It is converted and mapped the first time it is used, and
+// unmapped again when it is the least recently used tile and too many tiles are mapped.
+type lazyTile struct {
+	position TilePosition
+	fName    string
+
+	// load converts the tile if needed, and maps it
+	load func() (tileData, error)
+
+	// mapped points to the tileData while the tile is mapped, otherwise nil. It is accessed atomically.
+	mapped unsafe.Pointer
+
+	// lastUsed is the value of elevationTiles.clock when the tile was last used. It is accessed atomically.
+	lastUsed int64
+
+	// maxElevation is the Elevation16 maximum of the tile once it has been mapped, so that it is known after the
+	// tile is unmapped
+	maxElevation atomic.Value
+
+	// mutex makes sure the tile is loaded by one goroutine at a time. It protects err and failedAt.
+	mutex sync.Mutex
+
+	// err is set if the tile failed to load, and failedAt is the value of elevationTiles.acquired then. The tile is
+	// loaded again after the next call to Acquire, in case the error was transient.
+	err      error
+	failedAt int64
+}
+
+// generation counts the users that called Acquire between two evictions. Users may hold pointers into tiles
+// that were evicted after they called Acquire, so the tiles evicted at the end of a generation are only
+// unmapped when there are no users left in that generation or earlier generations.
+type generation struct {
+	refs    int
+	evicted []tileData
+}
+
+// loaded returns the tileData if the tile is mapped, otherwise nil
+func (tile *lazyTile) loaded() tileData {
+	if p := atomic.LoadPointer(&tile.mapped); p != nil {
+		return *(*tileData)(p)
+	}
+	return nil
+}
+
+// swap sets the tileData of the tile, or nil if the tile isn't mapped, and returns the previous tileData
+func (tile *lazyTile) swap(m tileData) tileData {
+	var p unsafe.Pointer
+	if m != nil {
+		p = unsafe.Pointer(&m)
+	}
+	if old := atomic.SwapPointer(&tile.mapped, p); old != nil {
+		return *(*tileData)(old)
+	}
+	return nil
+}
+
+// lookup returns the mapped tile, and maps it if needed. It returns nil if the tile can't be loaded.
+func (t *elevationTiles) lookup(tile *lazyTile) tileData {
+	if m := tile.loaded(); m != nil {
+		// The clock only changes when a tile is mapped, so this rarely writes
+		if clock := atomic.LoadInt64(&t.clock); atomic.LoadInt64(&tile.lastUsed) != clock {
+			atomic.StoreInt64(&tile.lastUsed, clock)
+		}
+		return m
+	}
+	return t.mapTile(tile)
+}
+
+// mapTile loads and maps the tile, and evicts the least recently used tile if too many tiles are mapped
+func (t *elevationTiles) mapTile(tile *lazyTile) tileData {
+	tile.mutex.Lock()
+	defer tile.mutex.Unlock()
+
+	if m := tile.loaded(); m != nil {
+		return m
+	}
+	acquired := atomic.LoadInt64(&t.acquired)
+	if tile.err != nil && tile.failedAt == acquired {
+		return nil
+	}
+
+	m, err := tile.load()
+	if err == nil && m.position() != tile.position {
+		m.Close()
+		err = fmt.Errorf("tile position %v differs from the indexed position %v", m.position(), tile.position)
+	}
+	if err != nil {
+		log.Printf("failed to load %s: %v", tile.fName, err)
+		tile.err, tile.failedAt = err, acquired
+		return nil
+	}
+	tile.err = nil
+
+	t.refMutex.Lock()
+	defer t.refMutex.Unlock()
+	if t.closed {
+		m.Close()
+		return nil
+	}
+
+	t.addMapped(tile, m)
+	return m
+}
+
+// addMapped adds a mapped tile, and evicts the least recently used tiles if too many tiles are mapped.
+// The refMutex must be held.
+func (t *elevationTiles) addMapped(tile *lazyTile, m tileData) {
+	atomic.StoreInt64(&tile.lastUsed, atomic.AddInt64(&t.clock, 1))
+	if tile.maxElevation.Load() == nil {
+		tile.maxElevation.Store(m.tileMaxElevation())
+		atomic.AddInt64(&t.knownTiles, 1)
+	}
+	tile.swap(m)
+	t.mapped = append(t.mapped, tile)
+
+	for t.maxMapped > 0 && len(t.mapped) > t.maxMapped {
+		t.evictLeastRecentlyUsed(tile)
+	}
+}
+
+// evictLeastRecentlyUsed evicts the least recently used tile, except the given tile. The refMutex must be held.
+func (t *elevationTiles) evictLeastRecentlyUsed(except *lazyTile) {
+	lru := -1
+	for i, tile := range t.mapped {
+		if tile != except && (lru < 0 || atomic.LoadInt64(&tile.lastUsed) < atomic.LoadInt64(&t.mapped[lru].lastUsed)) {
+			lru = i
+		}
+	}
+	if lru < 0 {
+		return
+	}
+
+	tile := t.mapped[lru]
+	t.mapped = append(t.mapped[:lru], t.mapped[lru+1:]...)
+	m := tile.swap(nil)
+
+	// The evicted tile ends the current generation
+	current := t.generations[len(t.generations)-1]
+	current.evicted = append(current.evicted, m)
+	t.generations = append(t.generations, &generation{})
+	t.unmapEvicted()
+}
+
+// unmapEvicted unmaps the evicted tiles that are no longer used. The refMutex must be held.
+func (t *elevationTiles) unmapEvicted() {
+	for len(t.generations) > 1 && t.generations[0].refs == 0 {
+		for _, m := range t.generations[0].evicted {
+			if err := m.Close(); err != nil {
+				log.Printf("failed to unmap tile %v: %v", m.position(), err)
+			}
+		}
+		t.generations = t.generations[1:]
+	}
+}
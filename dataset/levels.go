@@ -0,0 +1,159 @@
+package dataset
+
+import (
+	"unsafe"
+)
+
+// Tiles contain downsampled levels of their elevations, so that distant terrain can be traced with fewer steps.
+// Each point in level k is the mean of 2x2 points in level k-1, where level 0 is the elevation points of the tile.
+// The levels are stored for each maplet, and an ElevationMap for a level assembles them into ElevationMaplets of
+// the usual size, which cover 2^k x 2^k maplets of the tiles.
+const (
+	// MaxLevel is the coarsest level. A maplet has 200 / 2^MaxLevel points along each side in the coarsest level.
+	MaxLevel = 3
+
+	// levelPoints is the number of points in all the downsampled levels of a maplet
+	levelPoints = (ElevationMapletSize/2)*(ElevationMapletSize/2) +
+		(ElevationMapletSize/4)*(ElevationMapletSize/4) +
+		(ElevationMapletSize/8)*(ElevationMapletSize/8)
+)
+
+// mapletLevels contains the downsampled levels of a maplet, level 1 first. Each level is stored row by row.
+type mapletLevels [levelPoints]Elevation16
+
+// levelSize returns the number of points along each side of a maplet in the given level
+func levelSize(level int) int {
+	return ElevationMapletSize >> level
+}
+
+// level returns the points of a maplet in the given level, which must be between 1 and MaxLevel
+func (l *mapletLevels) level(level int) []Elevation16 {
+	offset := 0
+	for k := 1; k < level; k++ {
+		offset += levelSize(k) * levelSize(k)
+	}
+	return l[offset : offset+levelSize(level)*levelSize(level)]
+}
+
+// levelSizes is the sizes of the levels in mapletLevels
+var levelSizes = []int{levelSize(1), levelSize(2), levelSize(3)}
+
+// points returns the points of the maplet row by row
+func (m *ElevationMaplet) points() []Elevation16 {
+	return (*[mapletPoints]Elevation16)(unsafe.Pointer(m))[:]
+}
+
+// meanElevation returns the mean of the four elevations. Points without data are left out, but the mean is NoData
+// if more than half of them are NoData.
+func meanElevation(a Elevation16, b Elevation16, c Elevation16, d Elevation16) Elevation16 {
+	sum, count := 0, 0
+	for _, elevation := range [4]Elevation16{a, b, c, d} {
+		if elevation != NoData {
+			sum += int(elevation)
+			count++
+		}
+	}
+	if count < 2 {
+		return NoData
+	}
+
+	// Rounded to nearest, with halves away from zero
+	if sum < 0 {
+		return Elevation16(-((-2*sum + count) / (2 * count)))
+	}
+	return Elevation16((2*sum + count) / (2 * count))
+}
+
+// downsample computes the downsampled levels of the maplet
+func downsample(maplet *ElevationMaplet) *mapletLevels {
+	var levels mapletLevels
+	previous, previousSize := maplet.points(), ElevationMapletSize
+	for level := 1; level <= MaxLevel; level++ {
+		points, size := levels.level(level), levelSize(level)
+		for m := 0; m < size; m++ {
+			row0 := previous[2*m*previousSize:]
+			row1 := previous[(2*m+1)*previousSize:]
+			for n := 0; n < size; n++ {
+				points[m*size+n] = meanElevation(row0[2*n], row0[2*n+1], row1[2*n], row1[2*n+1])
+			}
+		}
+		previous, previousSize = points, size
+	}
+	return &levels
+}
+
+// Level returns an ElevationMap with the same tiles, using the downsampled elevations in the given level, which is
+// between 0 and MaxLevel. Level 0 is the elevations of the tiles.
+func (em *ElevationMap) Level(level int) ElevationMap {
+	return ElevationMap{
+		grid:       em.tiles.levelGrid(em.grid.zone, em.grid.spacing, level),
+		tiles:      em.tiles,
+		generation: em.generation,
+	}
+}
+
+// levelFactor returns the number of tile points along each side of a point in the grid
+func (g *zoneGrid) levelFactor() IntStep {
+	return 1 << g.level
+}
+
+// levelMaplet returns the maplet of the level, assembled from the downsampled maplets of the tiles. It returns nil
+// if there is no tile for any of them. The assembled maplets are kept in the maplet cache.
+func (em *ElevationMap) levelMaplet(e IntStep, n IntStep) *ElevationMaplet {
+	key := mapletKey{grid: em.grid, i: int(n / ElevationMapletSize), j: int(e / ElevationMapletSize)}
+	if maplet := em.tiles.maplets.get(key); maplet != nil {
+		return maplet
+	}
+
+	tiles := em.Level(0)
+	factor := em.grid.levelFactor()
+	size := levelSize(em.grid.level)
+	e0 := e / ElevationMapletSize * ElevationMapletSize * factor
+	n0 := n / ElevationMapletSize * ElevationMapletSize * factor
+
+	var maplet ElevationMaplet
+	found := false
+	for i := 0; i < int(factor); i++ {
+		for j := 0; j < int(factor); j++ {
+			points := tiles.downsampledMaplet(e0+IntStep(j*ElevationMapletSize), n0+IntStep(i*ElevationMapletSize), em.grid.level)
+			found = found || points != nil
+			for m := 0; m < size; m++ {
+				row := maplet[i*size+m][j*size : (j+1)*size]
+				if points == nil {
+					for n := range row {
+						row[n] = NoData
+					}
+					continue
+				}
+				copy(row, points[m*size:(m+1)*size])
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+	return em.tiles.maplets.add(key, &maplet)
+}
+
+// downsampledMaplet returns the points of the maplet in the given level, or nil if there is no tile. Maplets
+// resampled from other zones are downsampled when needed.
+func (em *ElevationMap) downsampledMaplet(e IntStep, n IntStep, level int) []Elevation16 {
+	if e < 0 || n < 0 {
+		return nil
+	}
+
+	mmapStruct := em.grid.lookupMmapStruct(int(e/bigSquareSize), int(n/bigSquareSize))
+	if mmapStruct == nil {
+		maplet := em.foreignMaplet(e, n)
+		if maplet == nil {
+			return nil
+		}
+		return downsample(maplet).level(level)
+	}
+
+	levels := mmapStruct.levels(index2(n), index2(e))
+	if levels == nil {
+		return nil
+	}
+	return levels.level(level)
+}
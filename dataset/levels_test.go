@@ -0,0 +1,102 @@
+package dataset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMeanElevation(t *testing.T) {
+	for _, tc := range []struct {
+		elevations [4]Elevation16
+		want       Elevation16
+	}{
+		{[4]Elevation16{1, 2, 3, 4}, 3},
+		{[4]Elevation16{-1, -2, -3, -4}, -3},
+		{[4]Elevation16{10, 10, 10, 11}, 10},
+		{[4]Elevation16{NoData, 10, 20, 30}, 20},
+		{[4]Elevation16{NoData, NoData, 20, 30}, 25},
+		{[4]Elevation16{NoData, NoData, NoData, 30}, NoData},
+		{[4]Elevation16{math.MaxInt16, math.MaxInt16, math.MaxInt16, math.MaxInt16}, math.MaxInt16},
+	} {
+		e := tc.elevations
+		if got := meanElevation(e[0], e[1], e[2], e[3]); got != tc.want {
+			t.Errorf("meanElevation(%v) = %d, want %d", e, got, tc.want)
+		}
+	}
+}
+
+func TestElevationMapLevels(t *testing.T) {
+	for _, options := range []LoadOptions{{Verify: true}, {Verify: true, Compress: true}} {
+		testElevationMapLevels(t, options)
+	}
+}
+
+func testElevationMapLevels(t *testing.T, options LoadOptions) {
+	west := tileAt(32, 61, 9)
+	east := west
+	east.MinEasting += west.size()
+	r := testReader{
+		tiles: map[string]TilePosition{
+			"west.dem": west,
+			"east.dem": east,
+		},
+		elevation: func(lat, lng float64) float64 {
+			return 1000*(lat-60) + 100*lng
+		},
+	}
+
+	em, report, cleanup := loadTestFilesWith(t, &r, &r, options)
+	defer cleanup()
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The points are in the west tile, near the east tile and in the east tile
+	utm := NewUTM(32)
+	for _, easting := range []float64{east.MinEasting - 3000, east.MinEasting + 5000} {
+		northing := west.MaxNorthing - 20_000
+		for level := 0; level <= MaxLevel; level++ {
+			m := em.Level(level)
+			spacing := m.Spacing()
+			if spacing != Unit*math.Pow(2, float64(level)) {
+				t.Fatalf("level %d has spacing %v", level, spacing)
+			}
+
+			minEasting, maxNorthing := m.Offsets()
+			e := IntStep(math.Round((easting - minEasting) / spacing))
+			n := IntStep(math.Round((maxNorthing - northing) / spacing))
+			lat, lng := utm.UTMToLatLng(minEasting+float64(e)*spacing, maxNorthing-float64(n)*spacing)
+			if got, want := m.Elevation(e, n), r.elevation(lat, lng); math.Abs(got-want) > 0.2 {
+				t.Errorf("elevation at level %d is %v, want %v", level, got, want)
+			}
+
+			// The maximum elevation includes the extra row and column
+			maplet := m.LookupElevationMaplet(e, n)
+			max := m.MaxElevation(e, n)
+			for i := 0; i <= ElevationMapletSize; i++ {
+				for j := 0; j <= ElevationMapletSize; j++ {
+					e1 := e/ElevationMapletSize*ElevationMapletSize + IntStep(j)
+					n1 := n/ElevationMapletSize*ElevationMapletSize + IntStep(i)
+					if elevation := m.Elevation(e1, n1); elevation > max {
+						t.Fatalf("elevation %v at level %d is above the maximum elevation %v", elevation, level, max)
+					}
+				}
+			}
+			if again := m.LookupElevationMaplet(e, n); level > 0 && again != maplet {
+				t.Errorf("maplet at level %d is assembled again", level)
+			}
+		}
+	}
+
+	// Points outside the tiles have no data
+	m := em.Level(MaxLevel)
+	minEasting, maxNorthing := m.Offsets()
+	e := IntStep(math.Round((west.MinEasting - 20_000 - minEasting) / m.Spacing()))
+	n := IntStep(math.Round((maxNorthing - west.MaxNorthing + 20_000) / m.Spacing()))
+	if got := m.Elevation(e, n); !math.IsNaN(got) {
+		t.Errorf("elevation outside the tiles is %v", got)
+	}
+	if got := m.LookupElevationMaplet(e, n+50*ElevationMapletSize); got != nil {
+		t.Errorf("found maplet far from the tiles")
+	}
+}
@@ -1,10 +1,16 @@
 package dataset
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"math"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"unsafe"
 )
@@ -15,27 +21,192 @@ type Elevation16 int16
 // Elevation16Unit should be used to convert Elevation16 to meter
 const Elevation16Unit = 0.1
 
+// NoData is the Elevation16 of points without elevation data, like sea, lakes and gaps in the elevation files.
+// It is not included in the maximum elevation of maplets.
+const NoData Elevation16 = math.MinInt16
+
 // mmap5000 contains elevation data stored on disk and loaded into memory using mmap.
 type mmap5000 struct {
 	EastingMin    float64
 	NorthingMax   float64
+	Zone          int64
+	Spacing       float64
+	MaxElevation  Elevation16
 	MaxElevations [numberOfElevationMaplets][numberOfElevationMaplets]Elevation16
 
 	// Elevations is a matrix of ElevationMaplets
 	Elevations [numberOfElevationMaplets][numberOfElevationMaplets]ElevationMaplet
+
+	// Levels contains the downsampled levels of each maplet
+	Levels [numberOfElevationMaplets][numberOfElevationMaplets]mapletLevels
+}
+
+// TilePosition is the position of a tile, given by the UTM zone, the top left elevation point and the spacing
+type TilePosition struct {
+	Zone        int
+	MinEasting  float64
+	MaxNorthing float64
+
+	// Spacing is the number of meters between the elevation points. A tile has bigSquareSize x bigSquareSize
+	// points, so a tile with 10 meters spacing is 50x50 km.
+	Spacing float64
+}
+
+// size returns the size of the tile in meters
+func (p TilePosition) size() float64 {
+	return p.Spacing * bigSquareSize
+}
+
+// checkSpacing returns an error if tiles can't use the given spacing. The spacing must be a whole number of meters
+// that divides 1000, so that the tiles are aligned with the grid origin.
+func checkSpacing(spacing float64) error {
+	if spacing < 1 || spacing != math.Trunc(spacing) || math.Mod(1000, spacing) != 0 {
+		return fmt.Errorf("unsupported spacing %v", spacing)
+	}
+	return nil
 }
 
 // Reader reads elevation data from a file and returns it as a matrix
 type Reader interface {
 	// ReadFile reads elevation data from a file and returns it as a matrix
-	ReadFile(fname string) (buffer [][]float32, minEasting float64, maxNorthing float64)
+	ReadFile(fname string) (buffer [][]float32, tile TilePosition, err error)
+}
+
+// TileReader is implemented by Readers that resample each file to several tiles, e.g. from a lat/lng grid.
+// Tiles from a TileReader have lower priority than tiles from other Readers.
+type TileReader interface {
+	Reader
+
+	// Tiles returns the position of the tiles that can be read from a file
+	Tiles(fname string) ([]TilePosition, error)
+
+	// ReadTile reads elevation data for a tile and returns it as a matrix
+	ReadTile(fname string, tile TilePosition) ([][]float32, error)
+}
+
+// SourceLister is implemented by TileReaders that read parts of the tiles from other files than the given file.
+// The tiles are regenerated when any of the files change.
+type SourceLister interface {
+	TileReader
+
+	// Sources returns the other files that ReadTile may read for the tile. The files may not exist.
+	Sources(fname string, tile TilePosition) ([]string, error)
+}
+
+// Locator is implemented by Readers that can find the position of the tile in a file without reading all of it.
+// Tiles from a Locator are read when they are first used.
+type Locator interface {
+	Reader
+
+	// Locate returns the position of the tile that ReadFile would return
+	Locate(fname string) (TilePosition, error)
+}
+
+// ReaderByExtension is a Reader that reads each file with the Reader for the file name extension, e.g. ".dem"
+type ReaderByExtension map[string]Reader
+
+// ReadFile reads the given file using the Reader for the file name extension
+func (r ReaderByExtension) ReadFile(fname string) (buffer [][]float32, tile TilePosition, err error) {
+	reader, err := r.readerFor(fname)
+	if err != nil {
+		return nil, tile, err
+	}
+	return reader.ReadFile(fname)
+}
+
+func (r ReaderByExtension) readerFor(fname string) (Reader, error) {
+	reader, ok := r[strings.ToLower(filepath.Ext(fname))]
+	if !ok {
+		return nil, fmt.Errorf("no reader for %s", filepath.Ext(fname))
+	}
+	return reader, nil
+}
+
+// readerFor returns the Reader to use for the given file
+func readerFor(datasetReader Reader, fname string) (Reader, error) {
+	if byExtension, ok := datasetReader.(ReaderByExtension); ok {
+		return byExtension.readerFor(fname)
+	}
+	return datasetReader, nil
 }
 
 const mmapStructSize = unsafe.Sizeof(mmap5000{})
 
-// Close does nothing today
-func (m *mmap5000) Close() error {
-	return nil
+// tileData is the elevation data of a tile that is mapped from an mmap file
+type tileData interface {
+	position() TilePosition
+
+	// maxElevation returns the maximum elevation in the maplet with the given maplet indices (row, column)
+	maxElevation(i int, j int) Elevation16
+
+	// tileMaxElevation returns the maximum elevation in the tile
+	tileMaxElevation() Elevation16
+
+	// maplet returns the maplet with the given maplet indices (row, column), or nil if it can't be read
+	maplet(i int, j int) *ElevationMaplet
+
+	// levels returns the downsampled levels of the maplet with the given maplet indices, or nil if they can't be
+	// read
+	levels(i int, j int) *mapletLevels
+
+	// Close unmaps the tile. The tile must not be used afterwards.
+	Close() error
+}
+
+// mappedTile is an mmap5000 that is mapped into memory from an mmap file
+type mappedTile struct {
+	*mmap5000
+
+	// data is the mapped memory, including the header
+	data []byte
+}
+
+func (t *mappedTile) maxElevation(i int, j int) Elevation16 {
+	return t.MaxElevations[i][j]
+}
+
+func (t *mappedTile) tileMaxElevation() Elevation16 {
+	return t.MaxElevation
+}
+
+func (t *mappedTile) maplet(i int, j int) *ElevationMaplet {
+	return &t.Elevations[i][j]
+}
+
+func (t *mappedTile) levels(i int, j int) *mapletLevels {
+	return &t.Levels[i][j]
+}
+
+// Close unmaps the tile. The tile must not be used afterwards.
+func (t *mappedTile) Close() error {
+	return syscall.Munmap(t.data)
+}
+
+// tileFormat is the format of the mmap files, and how they are opened
+type tileFormat struct {
+	// compress selects compressed mmap files. Their maplets are decompressed into cache when used.
+	compress bool
+	cache    *mapletCache
+
+	// verify enables verification of the payload checksum
+	verify bool
+}
+
+// extension returns the file name extension of mmap files in the format
+func (f tileFormat) extension() string {
+	if f.compress {
+		return ".cmap"
+	}
+	return ".mmap"
+}
+
+// toElevation16 converts elevation in meters to Elevation16. Void elevations from Readers become NoData, and
+// other elevations are limited to the range of Elevation16.
+func toElevation16(elevation float64) Elevation16 {
+	if math.IsNaN(elevation) || elevation <= voidElevation {
+		return NoData
+	}
+	return Elevation16(math.Max(math.MinInt16+1, math.Min(math.MaxInt16, math.Round(elevation/Elevation16Unit))))
 }
 
 func toMmapStruct(buf [][]float32) *mmap5000 {
@@ -51,8 +222,7 @@ func toMmapStruct(buf [][]float32) *mmap5000 {
 				for n := 0; n <= ElevationMapletSize; n++ {
 					col := j*ElevationMapletSize + n
 
-					floatVal := buf[row][col]
-					intVal := Elevation16(math.Round(float64(floatVal) / Elevation16Unit))
+					intVal := toElevation16(float64(buf[row][col]))
 
 					if m < ElevationMapletSize && n < ElevationMapletSize {
 						result.Elevations[i][j][m][n] = intVal
@@ -63,52 +233,249 @@ func toMmapStruct(buf [][]float32) *mmap5000 {
 					}
 				}
 			}
+			result.Levels[i][j] = *downsample(&result.Elevations[i][j])
+			if result.MaxElevations[i][j] > result.MaxElevation {
+				result.MaxElevation = result.MaxElevations[i][j]
+			}
 		}
 	}
 	return &result
 }
 
 // loadAsMmap will load the given fname using syscall.mmap
-// The data can be accessed through the returned *mmap5000.
-// The returned *os.File should be syscall.munmapped to release the resource.
-func loadAsMmap(datasetReader Reader, mmapFileDir string, fname string) (*mmap5000, error) {
-	mmapFName := mmapFileDir + "/" + path.Base(fname) + ".mmap"
-	fileInfo, err := os.Stat(fname)
+// The data can be accessed through the returned tileData, which should be closed to release the resource.
+func loadAsMmap(datasetReader Reader, mmapFileDir string, fname string, format tileFormat) (tileData, error) {
+	return loadFileAsMmap(fname, nil, mmapFileDir+"/"+path.Base(fname)+format.extension(), format, func() ([][]float32, TilePosition, error) {
+		return datasetReader.ReadFile(fname)
+	})
+}
+
+// loadTileAsMmap is like loadAsMmap for one of the tiles from a TileReader
+func loadTileAsMmap(tileReader TileReader, mmapFileDir string, fname string, tile TilePosition, format tileFormat) (tileData, error) {
+	mmapFName := fmt.Sprintf("%s/%s.z%d_%.0f_%.0f%s", mmapFileDir, path.Base(fname), tile.Zone, tile.MinEasting, tile.MaxNorthing, format.extension())
+	var others []string
+	if lister, ok := tileReader.(SourceLister); ok {
+		var err error
+		if others, err = lister.Sources(fname, tile); err != nil {
+			return nil, err
+		}
+	}
+	return loadFileAsMmap(fname, others, mmapFName, format, func() ([][]float32, TilePosition, error) {
+		buf, err := tileReader.ReadTile(fname, tile)
+		return buf, tile, err
+	})
+}
+
+// loadFileAsMmap loads mmapFName, which is (re)generated using read if it doesn't match the format used by this
+// program or the contents of fname and the other files.
+// Generation is protected by an advisory lock, so that several processes can share the mmap files.
+func loadFileAsMmap(fname string, others []string, mmapFName string, format tileFormat, read func() ([][]float32, TilePosition, error)) (tileData, error) {
+	source, err := statSource(fname, others)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := openValidMmapped(mmapFName, &source, format)
+	if err == nil {
+		return m, nil
+	}
+
+	lock, err := lockMmapFile(mmapFName, true)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+
+	// Another process may have generated the file while waiting for the lock
+	m, err = openValidMmapped(mmapFName, &source, format)
+	if err == nil {
+		return m, nil
+	}
+	if !os.IsNotExist(err) {
+		log.Printf("regenerating %s: %v", mmapFName, err)
+	}
+
+	if err = source.computeHash(); err != nil {
+		return nil, err
+	}
+	if err = writeMmapped(read, source, mmapFName, format.compress); err != nil {
+		return nil, err
+	}
+
+	header, err := readMmapHeader(mmapFName)
+	if err != nil {
+		return nil, err
+	}
+	return openTile(mmapFName, &header, format)
+}
+
+// openValidMmapped opens mmapFName if it matches the format used by this program and the source file
+func openValidMmapped(mmapFName string, source *sourceFile, format tileFormat) (tileData, error) {
+	header, err := readMmapHeader(mmapFName)
 	if err != nil {
 		return nil, err
 	}
+	if err = header.checkFormat(); err != nil {
+		return nil, err
+	}
+	if err = header.checkSource(source); err != nil {
+		return nil, err
+	}
+	return openTile(mmapFName, &header, format)
+}
 
-	mmapFileInfo, err := os.Stat(mmapFName)
-	if err != nil || fileInfo.ModTime().After(mmapFileInfo.ModTime()) || mmapFileInfo.Size() != int64(mmapStructSize) {
-		err = writeMmapped(datasetReader, fname, mmapFName)
+// openTile maps the tile in the given mmap file, which has the given header
+func openTile(mmapFName string, header *mmapHeader, format tileFormat) (tileData, error) {
+	if header.Magic == compressedMagic {
+		m, err := openCompressed(mmapFName, header, format.verify, format.cache)
 		if err != nil {
 			return nil, err
 		}
+		return m, nil
 	}
 
-	return openMmapped(mmapFName)
+	m, err := openMmapped(mmapFName, header, format.verify)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
 }
 
-func writeMmapped(datasetReader Reader, fname string, mmapFname string) error {
-	buf, e, n := datasetReader.ReadFile(fname)
+// lockMmapFile takes an advisory lock for generating mmapFName. The lock is released by closing the returned file.
+// It fails if the lock is held by someone else, unless wait is set.
+func lockMmapFile(mmapFName string, wait bool) (*os.File, error) {
+	file, err := os.OpenFile(mmapFName+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	if err = syscall.Flock(int(file.Fd()), how); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// mmapTempSuffix is the suffix of temporary files used while writing mmap files. The full name is the name of the
+// mmap file, followed by a random string and the suffix.
+const mmapTempSuffix = ".tmp"
+
+// writeMmapped writes the mmap file to a temporary file that replaces mmapFname when it is complete, so that
+// other processes never see a partially written file. The file is compressed if compress is set.
+func writeMmapped(read func() ([][]float32, TilePosition, error), source sourceFile, mmapFname string, compress bool) error {
+	buf, tile, err := read()
+	if err != nil {
+		return err
+	}
 	mmapData := toMmapStruct(buf)
-	mmapData.EastingMin = e
-	mmapData.NorthingMax = n
+	mmapData.EastingMin = tile.MinEasting
+	mmapData.NorthingMax = tile.MaxNorthing
+	mmapData.Spacing = tile.Spacing
+	mmapData.Zone = int64(tile.Zone)
+
+	header := newMmapHeader(mmapData, source)
+	payload := (*(*[mmapStructSize]byte)(unsafe.Pointer(mmapData)))[:]
+	if compress {
+		if payload, err = compressTile(mmapData); err != nil {
+			return err
+		}
+		header.setCompressedPayload(payload)
+	}
+	headerBytes, err := header.encode()
+	if err != nil {
+		return err
+	}
 
-	var bytes = (*(*[mmapStructSize]byte)(unsafe.Pointer(mmapData)))[:]
-	return ioutil.WriteFile(mmapFname, bytes, 0644)
+	file, err := ioutil.TempFile(filepath.Dir(mmapFname), filepath.Base(mmapFname)+".*"+mmapTempSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err = file.Write(append(headerBytes, payload...)); err != nil {
+		return err
+	}
+	if err = file.Chmod(0644); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), mmapFname)
 }
 
-func openMmapped(fname string) (*mmap5000, error) {
+// removeStaleTempFiles removes temporary files left behind by processes that died while writing mmap files.
+// Temporary files are only removed if nobody holds the lock for the mmap file.
+func removeStaleTempFiles(mmapFileDir string) {
+	var tempFNames []string
+	for _, format := range []tileFormat{{}, {compress: true}} {
+		fNames, err := filepath.Glob(filepath.Join(mmapFileDir, "*"+format.extension()+".*"+mmapTempSuffix))
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		tempFNames = append(tempFNames, fNames...)
+	}
+
+	for _, tempFName := range tempFNames {
+		mmapFName := strings.TrimSuffix(tempFName, mmapTempSuffix)
+		mmapFName = mmapFName[:strings.LastIndex(mmapFName, ".")]
+
+		lock, err := lockMmapFile(mmapFName, false)
+		if err != nil {
+			continue
+		}
+		log.Printf("removing stale %s", tempFName)
+		if err = os.Remove(tempFName); err != nil && !os.IsNotExist(err) {
+			log.Print(err)
+		}
+		lock.Close()
+	}
+}
+
+// openMmapped maps the mmap5000 in the given file, which has the given header
+func openMmapped(fname string, header *mmapHeader, verify bool) (*mappedTile, error) {
 	file, err := os.OpenFile(fname, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	data, err := syscall.Mmap(int(file.Fd()), 0, int(mmapStructSize), syscall.PROT_READ, syscall.MAP_SHARED)
+	data, err := syscall.Mmap(int(file.Fd()), 0, mmapHeaderSize+int(mmapStructSize), syscall.PROT_READ, syscall.MAP_SHARED)
 	if err != nil {
 		return nil, err
 	}
-	return (*mmap5000)(unsafe.Pointer(&data[0])), nil
+
+	m := (*mmap5000)(unsafe.Pointer(&data[mmapHeaderSize]))
+	if header.CRS != crsName(int(m.Zone)) {
+		syscall.Munmap(data)
+		return nil, fmt.Errorf("unexpected zone %d for %s", m.Zone, string(bytes.TrimRight(header.CRS[:], "\x00")))
+	}
+	if header.Unit != m.Spacing {
+		syscall.Munmap(data)
+		return nil, fmt.Errorf("unexpected spacing %v, want %v", m.Spacing, header.Unit)
+	}
+	if verify && m.checksum() != header.PayloadChecksum {
+		syscall.Munmap(data)
+		return nil, errors.New("checksum mismatch")
+	}
+	return &mappedTile{mmap5000: m, data: data}, nil
+}
+
+// position returns the TilePosition of the tile
+func (m *mmap5000) position() TilePosition {
+	return TilePosition{
+		Zone:        int(m.Zone),
+		MinEasting:  m.EastingMin,
+		MaxNorthing: m.NorthingMax,
+		Spacing:     m.Spacing,
+	}
 }
@@ -0,0 +1,218 @@
+package dataset
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadFileAsMmap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fName := filepath.Join(dir, "source.dem")
+	mmapFName := fName + ".mmap"
+	if err = ioutil.WriteFile(fName, []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tile is also read from another file, which doesn't exist at first
+	other := filepath.Join(dir, "other.dem")
+
+	tile := TilePosition{Zone: 33, MinEasting: 100_000, MaxNorthing: 7_000_000, Spacing: Unit}
+	reads := 0
+	read := func() ([][]float32, TilePosition, error) {
+		reads++
+		buffer := make([][]float32, bigSquareSize+1)
+		for i := range buffer {
+			buffer[i] = make([]float32, bigSquareSize+1)
+			buffer[i][i] = 42
+		}
+		return buffer, tile, nil
+	}
+
+	// modify changes the mmap file at the given offset
+	modify := func(offset int64, b byte) {
+		f, err := os.OpenFile(mmapFName, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err = f.WriteAt([]byte{b}, offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// touch sets the modification time of the source file, and touchOther of the other file
+	modTime := time.Now()
+	touchFile := func(fName string) {
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(fName, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch := func() { touchFile(fName) }
+	touchOther := func() { touchFile(other) }
+
+	for _, tc := range []struct {
+		name   string
+		change func()
+		verify bool
+		reads  int
+	}{
+		{"new", func() {}, false, 1},
+		{"unchanged", func() {}, true, 1},
+		{"version", func() { modify(8, mmapFormatVersion+1) }, false, 2},
+		{"magic", func() { modify(0, 'X') }, false, 3},
+		{"payload without verify", func() { modify(mmapHeaderSize+100_000, 1) }, false, 3},
+		{"payload", func() {}, true, 4},
+		{"touched source", touch, false, 4},
+		{"changed source", func() {
+			ioutil.WriteFile(fName, []byte("SOURCE"), 0644)
+			touch()
+		}, false, 5},
+		{"truncated", func() { os.Truncate(mmapFName, mmapHeaderSize) }, false, 6},
+		{"new other file", func() { ioutil.WriteFile(other, []byte("other"), 0644) }, false, 7},
+		{"touched other file", touchOther, false, 7},
+		{"changed other file", func() {
+			ioutil.WriteFile(other, []byte("OTHER"), 0644)
+			touchOther()
+		}, false, 8},
+		{"removed other file", func() { os.Remove(other) }, false, 9},
+	} {
+		tc.change()
+		m, err := loadFileAsMmap(fName, []string{other}, mmapFName, tileFormat{verify: tc.verify}, read)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if reads != tc.reads {
+			t.Errorf("%s: source read %d times, want %d", tc.name, reads, tc.reads)
+		}
+		if m.position() != tile || m.maplet(1, 1)[0][0] != 420 {
+			t.Errorf("%s: unexpected contents at %v", tc.name, m.position())
+		}
+		if err = m.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestLoadFileAsMmapConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fName := filepath.Join(dir, "source.dem")
+	mmapFName := fName + ".mmap"
+	if err = ioutil.WriteFile(fName, []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var reads int32
+	read := func() ([][]float32, TilePosition, error) {
+		atomic.AddInt32(&reads, 1)
+		buffer := make([][]float32, bigSquareSize+1)
+		for i := range buffer {
+			buffer[i] = make([]float32, bigSquareSize+1)
+		}
+		return buffer, TilePosition{Zone: 32, Spacing: Unit}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m, err := loadFileAsMmap(fName, nil, mmapFName, tileFormat{verify: true}, read)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			m.Close()
+		}()
+	}
+	wg.Wait()
+
+	if reads != 1 {
+		t.Errorf("source read %d times, want 1", reads)
+	}
+}
+
+func TestRemoveStaleTempFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stale := filepath.Join(dir, "a.dem.mmap.123"+mmapTempSuffix)
+	inProgress := filepath.Join(dir, "b.dem.mmap.456"+mmapTempSuffix)
+	for _, fName := range []string{stale, inProgress} {
+		if err = ioutil.WriteFile(fName, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lock, err := lockMmapFile(filepath.Join(dir, "b.dem.mmap"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Close()
+
+	removeStaleTempFiles(dir)
+
+	if _, err = os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale temp file was not removed: %v", err)
+	}
+	if _, err = os.Stat(inProgress); err != nil {
+		t.Errorf("temp file with lock was removed: %v", err)
+	}
+}
+
+func TestToMmapStructNoData(t *testing.T) {
+	buffer := make([][]float32, bigSquareSize+1)
+	for i := range buffer {
+		buffer[i] = make([]float32, bigSquareSize+1)
+	}
+	buffer[0][0] = voidElevation
+	buffer[0][1] = float32(math.NaN())
+	buffer[0][2] = 5000
+	buffer[0][3] = -5000
+	buffer[0][ElevationMapletSize] = -40_000
+
+	m := toMmapStruct(buffer)
+	got := m.Elevations[0][0][0][:4]
+	want := []Elevation16{NoData, NoData, math.MaxInt16, math.MinInt16 + 1}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("elevation %d is %d, want %d", i, got[i], want[i])
+		}
+	}
+	if m.Elevations[0][1][0][0] != NoData {
+		t.Errorf("large negative elevation is %d, want NoData", m.Elevations[0][1][0][0])
+	}
+	if m.MaxElevations[0][0] != math.MaxInt16 || m.MaxElevations[0][1] != 0 {
+		t.Errorf("unexpected max elevations %d and %d", m.MaxElevations[0][0], m.MaxElevations[0][1])
+	}
+}
+
+func TestCRSName(t *testing.T) {
+	// The name is the same for WGS 84 and ETRS89 sources
+	for zone, want := range map[int]string{32: "UTM 32N", 5: "UTM 5N"} {
+		crs := crsName(zone)
+		if got := string(bytes.TrimRight(crs[:], "\x00")); got != want {
+			t.Errorf("crsName(%d) = %q, want %q", zone, got, want)
+		}
+	}
+}
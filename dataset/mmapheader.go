@@ -0,0 +1,254 @@
+package dataset
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"unsafe"
+)
+
+const (
+	// mmapFormatVersion must be increased when the layout of mmap5000 or the meaning of its contents changes
+	mmapFormatVersion = 6
+
+	// mmapHeaderSize is the space reserved for the header in front of the mmap5000. It is a multiple of the page
+	// size, so that the mmap5000 is aligned like the mapped memory.
+	mmapHeaderSize = 4096
+)
+
+// mmapMagic identifies mmap files
+var mmapMagic = [8]byte{'B', 'L', 'A', 'N', 'E', 'M', 'A', 'P'}
+
+// mmapHeader describes the contents of an mmap file. The header is stored in little endian byte order, while the
+// mmap5000 that follows it is stored in the byte order of the machine that wrote it. Compressed mmap files have
+// another magic and payload, see compressedDirectory.
+type mmapHeader struct {
+	Magic   [8]byte
+	Version uint32
+
+	// ByteOrder is the bytes of mmapByteOrderMark in the byte order of the mmap5000
+	ByteOrder [4]byte
+
+	// TileSize and MapletSize is the number of elevation points along each side of a tile and a maplet
+	TileSize   uint32
+	MapletSize uint32
+
+	// Unit is the spacing of the tile, and ElevationUnit is the unit of Elevation16 (meters)
+	Unit          float64
+	ElevationUnit float64
+
+	// CRS identifies the coordinate reference system of the tile by the UTM zone, like "UTM 32N". The datum is
+	// the datum of the source, which is WGS 84 or ETRS89 and differs by less than a meter in Europe.
+	CRS [16]byte
+
+	// SourceSize, SourceModTime and SourceHash identifies the contents of the file the tile was read from.
+	// SourceModTime is in nanoseconds since the unix epoch. For tiles that are also read from other files, they
+	// are sums over the files and the hash of the hashes of the files, see sourceFile.
+	SourceSize    int64
+	SourceModTime int64
+	SourceHash    [sha256.Size]byte
+
+	// PayloadSize and PayloadChecksum is the size and CRC-32 checksum of the mmap5000 or the compressed payload
+	PayloadSize     uint64
+	PayloadChecksum uint32
+}
+
+const mmapByteOrderMark = 0x01020304
+
+// nativeByteOrder returns the bytes of mmapByteOrderMark in the byte order of this machine
+func nativeByteOrder() [4]byte {
+	mark := uint32(mmapByteOrderMark)
+	return *(*[4]byte)(unsafe.Pointer(&mark))
+}
+
+// crsName returns the CRS identifier for a northern UTM zone. The sources don't agree on the datum, so it is not
+// part of the identifier.
+func crsName(zone int) (crs [16]byte) {
+	copy(crs[:], fmt.Sprintf("UTM %dN", zone))
+	return crs
+}
+
+// newMmapHeader returns the header for the given mmap5000 read from the given source file
+func newMmapHeader(m *mmap5000, source sourceFile) mmapHeader {
+	return mmapHeader{
+		Magic:           mmapMagic,
+		Version:         mmapFormatVersion,
+		ByteOrder:       nativeByteOrder(),
+		TileSize:        bigSquareSize,
+		MapletSize:      ElevationMapletSize,
+		Unit:            m.Spacing,
+		ElevationUnit:   Elevation16Unit,
+		CRS:             crsName(int(m.Zone)),
+		SourceSize:      source.size,
+		SourceModTime:   source.modTime,
+		SourceHash:      source.hash,
+		PayloadSize:     uint64(mmapStructSize),
+		PayloadChecksum: m.checksum(),
+	}
+}
+
+// checkFormat returns an error if the header is for another format than the one used by this program
+func (h *mmapHeader) checkFormat() error {
+	switch {
+	case h.Magic != mmapMagic && h.Magic != compressedMagic:
+		return errors.New("not an mmap file")
+	case h.Version != mmapFormatVersion:
+		return fmt.Errorf("format version %d, want %d", h.Version, mmapFormatVersion)
+	case h.TileSize != bigSquareSize || h.MapletSize != ElevationMapletSize:
+		return fmt.Errorf("tile size %d/%d, want %d/%d", h.TileSize, h.MapletSize, bigSquareSize, ElevationMapletSize)
+	case checkSpacing(h.Unit) != nil || h.ElevationUnit != Elevation16Unit:
+		return fmt.Errorf("unit %v/%v, want a valid spacing and %v", h.Unit, h.ElevationUnit, Elevation16Unit)
+	case h.Magic == compressedMagic:
+		// The compressed payload is little endian, and has a variable size
+	case h.ByteOrder != nativeByteOrder():
+		return errors.New("byte order mismatch")
+	case h.PayloadSize != uint64(mmapStructSize):
+		return fmt.Errorf("payload size %d, want %d", h.PayloadSize, mmapStructSize)
+	}
+	return nil
+}
+
+func (h *mmapHeader) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, h); err != nil {
+		return nil, err
+	}
+	return append(buf.Bytes(), make([]byte, mmapHeaderSize-buf.Len())...), nil
+}
+
+// readMmapHeader reads the header of the given mmap file
+func readMmapHeader(fname string) (h mmapHeader, err error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return h, err
+	}
+	defer file.Close()
+
+	if err = binary.Read(file, binary.LittleEndian, &h); err != nil {
+		return h, err
+	}
+
+	// Accessing mapped memory beyond the end of the file causes SIGBUS
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return h, err
+	}
+	if fileInfo.Size() != mmapHeaderSize+int64(h.PayloadSize) {
+		return h, fmt.Errorf("unexpected file size %d", fileInfo.Size())
+	}
+	return h, nil
+}
+
+// checksum returns the CRC-32 checksum of the contents
+func (m *mmap5000) checksum() uint32 {
+	return crc32.ChecksumIEEE((*(*[mmapStructSize]byte)(unsafe.Pointer(m)))[:])
+}
+
+// sourceFile identifies the contents of a file that tiles are read from, and the other files that the tiles are
+// also read from. The size and modTime are sums over the files that exist, so that changing, adding or removing
+// any of them changes at least one of them in practice.
+type sourceFile struct {
+	fname   string
+	others  []string
+	size    int64
+	modTime int64
+	hash    [sha256.Size]byte
+}
+
+// statSource returns the sourceFile for the given file name and other files, without the hash. The other files may
+// not exist.
+func statSource(fname string, others []string) (sourceFile, error) {
+	fileInfo, err := os.Stat(fname)
+	if err != nil {
+		return sourceFile{}, err
+	}
+	source := sourceFile{
+		fname:   fname,
+		others:  others,
+		size:    fileInfo.Size(),
+		modTime: fileInfo.ModTime().UnixNano(),
+	}
+
+	for _, other := range others {
+		fileInfo, err := os.Stat(other)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return sourceFile{}, err
+		}
+		source.size += fileInfo.Size()
+		source.modTime += fileInfo.ModTime().UnixNano()
+	}
+	return source, nil
+}
+
+// computeHash computes the hash of the contents of the file. With other files, it is the hash of the hashes of all
+// the files, with the names of the other files.
+func (s *sourceFile) computeHash() error {
+	hash, err := fileHash(s.fname)
+	if err != nil {
+		return err
+	}
+	if len(s.others) == 0 {
+		s.hash = hash
+		return nil
+	}
+
+	h := sha256.New()
+	h.Write(hash[:])
+	for _, other := range s.others {
+		hash, err := fileHash(other)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		io.WriteString(h, filepath.Base(other))
+		h.Write(hash[:])
+	}
+	copy(s.hash[:], h.Sum(nil))
+	return nil
+}
+
+// fileHash returns the hash of the contents of the file
+func fileHash(fname string) (hash [sha256.Size]byte, err error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return hash, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return hash, err
+	}
+	copy(hash[:], h.Sum(nil))
+	return hash, nil
+}
+
+// checkSource returns an error if the header is for another version of the source file. The hash is only computed
+// if the modification time has changed, so touching a file doesn't cause regeneration.
+func (h *mmapHeader) checkSource(source *sourceFile) error {
+	if h.SourceSize != source.size {
+		return errors.New("source file size changed")
+	}
+	if h.SourceModTime == source.modTime {
+		return nil
+	}
+
+	if err := source.computeHash(); err != nil {
+		return err
+	}
+	if h.SourceHash != source.hash {
+		return errors.New("source file changed")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package dataset
+
+import (
+	"fmt"
+	"math"
+)
+
+// PointElevation is the elevation at a position, and where it is from
+type PointElevation struct {
+	// Elevation is in meters, interpolated bilinearly between the elevation points
+	Elevation float64
+
+	// Zone, Easting and Northing is the position in the UTM zone of the tile
+	Zone     int
+	Easting  float64
+	Northing float64
+
+	// Tile is the tile with the elevation data, and FName is the file it is read from
+	Tile  TilePosition
+	FName string
+}
+
+// NoDataError is returned for positions without elevation data
+type NoDataError struct {
+	Lat float64
+	Lng float64
+
+	// InTile is true if there is a tile at the position, but it has no elevation data there, like sea
+	InTile bool
+}
+
+func (e *NoDataError) Error() string {
+	if e.InTile {
+		return fmt.Sprintf("no elevation data at %.6f, %.6f", e.Lat, e.Lng)
+	}
+	return fmt.Sprintf("no tile at %.6f, %.6f", e.Lat, e.Lng)
+}
+
+// ElevationAtLatLng returns the elevation at the given lat/lng from the tile with the finest spacing that has
+// elevation data there. Tiles in the standard UTM zone for the position are preferred. It returns a *NoDataError
+// if there is no elevation data.
+func (em *ElevationMap) ElevationAtLatLng(lat float64, lng float64) (PointElevation, error) {
+	return em.pointElevation(lat, lng, utmZone(lat, lng))
+}
+
+// ElevationAtUTM is like ElevationAtLatLng for easting/northing in the zone of the ElevationMap, which is preferred.
+// Unlike ElevationAt, it is not limited to the grid of the ElevationMap.
+func (em *ElevationMap) ElevationAtUTM(easting float64, northing float64) (PointElevation, error) {
+	utm := NewUTM(em.grid.zone)
+	lat, lng := utm.UTMToLatLng(easting, northing)
+	return em.pointElevation(lat, lng, em.grid.zone)
+}
+
+// pointElevation returns the elevation at lat/lng from the finest spacing, trying the preferred zone first
+func (em *ElevationMap) pointElevation(lat float64, lng float64, preferredZone int) (PointElevation, error) {
+	zones := []int{preferredZone}
+	for _, zone := range em.tiles.zones {
+		if zone != preferredZone {
+			zones = append(zones, zone)
+		}
+	}
+
+	noData := &NoDataError{Lat: lat, Lng: lng}
+	for _, spacing := range em.tiles.spacings {
+		for _, zone := range zones {
+			utm := NewUTM(zone)
+			easting, northing := utm.LatLngToUTM(lat, lng)
+			tile := em.tiles.grid(zone, spacing).tileAt(easting, northing)
+			if tile == nil {
+				continue
+			}
+
+			noData.InTile = true
+			grid := em.inGrid(zone, spacing, 0)
+			elevation := grid.ElevationAt(easting, northing)
+			if math.IsNaN(elevation) {
+				continue
+			}
+
+			return PointElevation{
+				Elevation: elevation,
+				Zone:      zone,
+				Easting:   easting,
+				Northing:  northing,
+				Tile:      tile.position,
+				FName:     tile.fName,
+			}, nil
+		}
+	}
+	return PointElevation{}, noData
+}
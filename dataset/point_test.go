@@ -0,0 +1,75 @@
+package dataset
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestElevationAtLatLng(t *testing.T) {
+	coarse := tileAt(32, 61, 9)
+	fine := tileWithSpacingAt(32, 61, 9, 1)
+	r := testReader{
+		tiles: map[string]TilePosition{
+			"coarse.dem":  coarse,
+			"fine.dem":    fine,
+			"foreign.dem": tileAt(33, 61, 16),
+		},
+		elevation: func(lat, lng float64) float64 {
+			if lat > 61.2 {
+				return voidElevation
+			}
+			return 1000*(lat-60) + 100*lng
+		},
+	}
+
+	em, report, cleanup := loadTestFiles(t, &r)
+	defer cleanup()
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		lat, lng float64
+		tile     string
+		zone     int
+	}{
+		{61, 9, "fine.dem", 32},
+		{60.9, 9.2, "coarse.dem", 32},
+		{61, 16, "foreign.dem", 33},
+	} {
+		p, err := em.ElevationAtLatLng(tc.lat, tc.lng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := r.elevation(tc.lat, tc.lng); math.Abs(p.Elevation-want) > 0.2 {
+			t.Errorf("elevation at %v, %v is %v, want %v", tc.lat, tc.lng, p.Elevation, want)
+		}
+		if filepath.Base(p.FName) != tc.tile || p.Tile != r.tiles[tc.tile] || p.Zone != tc.zone {
+			t.Errorf("elevation at %v, %v is from %s %v in zone %d, want %s", tc.lat, tc.lng, p.FName, p.Tile, p.Zone, tc.tile)
+		}
+
+		// The same position in UTM gives the same elevation
+		utm := NewUTM(p.Zone)
+		zoneMap := em.InZone(p.Zone)
+		easting, northing := utm.LatLngToUTM(tc.lat, tc.lng)
+		if q, err := zoneMap.ElevationAtUTM(easting, northing); err != nil || math.Abs(q.Elevation-p.Elevation) > 1e-3 {
+			t.Errorf("elevation at %v, %v is %v, %v, want %v", easting, northing, q.Elevation, err, p.Elevation)
+		}
+	}
+
+	for _, tc := range []struct {
+		lat, lng float64
+		inTile   bool
+	}{
+		{61.3, 9, true},
+		{55, 9, false},
+	} {
+		_, err := em.ElevationAtLatLng(tc.lat, tc.lng)
+		var noData *NoDataError
+		if !errors.As(err, &noData) || noData.InTile != tc.inTile {
+			t.Errorf("error at %v, %v is %v, want no data in tile %v", tc.lat, tc.lng, err, tc.inTile)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package dataset
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// EarthRadius is the radius of the earth in meters, where the earth is assumed to be a sphere
+const EarthRadius = 6_371_000.0
+
+// minProfileSin is the smallest sine of the angle between the end points of a profile that are not at the same
+// position. The interpolation between the end points is unstable when they are nearly antipodal.
+const minProfileSin = 1e-9
+
+// ProfilePoint is a point on an elevation profile
+type ProfilePoint struct {
+	// Distance is the distance from the start of the profile in meters
+	Distance float64
+	Lat      float64
+	Lng      float64
+
+	// Elevation is from ElevationAtLatLng, or NaN if there is no elevation data
+	Elevation float64
+
+	// CurvedElevation is Elevation lowered by the earth curvature as seen from the start of the profile, like the
+	// terrain in the rendered views. A straight line between two points in the profile is a line of sight.
+	CurvedElevation float64
+}
+
+// Profile returns the elevations along the great circle from lat0/lng0 to lat1/lng1, with spacing meters between
+// the points. The last point is at lat1/lng1, so it may be closer to the point before. It returns an error if the
+// spacing is not positive, a position is not finite, or the end points are antipodal, where there is no single great
+// circle between them.
+func (em *ElevationMap) Profile(lat0 float64, lng0 float64, lat1 float64, lng1 float64, spacing float64) ([]ProfilePoint, error) {
+	p0, p1 := unitVector(lat0, lng0), unitVector(lat1, lng1)
+	distance := GreatCircleDistance(lat0, lng0, lat1, lng1)
+	angle := distance / EarthRadius
+	switch {
+	case !(spacing > 0) || math.IsInf(spacing, 1):
+		return nil, fmt.Errorf("invalid spacing %v", spacing)
+	case math.IsNaN(distance) || math.IsInf(distance, 0):
+		return nil, errors.New("invalid position")
+	case distance > 0 && math.Sin(angle) < minProfileSin:
+		return nil, errors.New("the end points are antipodal")
+	}
+
+	// The points are at multiples of spacing before the last point
+	count := int(math.Ceil(distance / spacing))
+	points := make([]ProfilePoint, 0, count+1)
+	for i := 0; i <= count; i++ {
+		d := float64(i) * spacing
+
+		// Spherical linear interpolation between the end points
+		lat, lng := lat0, lng0
+		if i == count {
+			d, lat, lng = distance, lat1, lng1
+		} else if i > 0 {
+			a, b := math.Sin(angle-d/EarthRadius)/math.Sin(angle), math.Sin(d/EarthRadius)/math.Sin(angle)
+			lat, lng = latLng([3]float64{a*p0[0] + b*p1[0], a*p0[1] + b*p1[1], a*p0[2] + b*p1[2]})
+		}
+
+		elevation := math.NaN()
+		if p, err := em.ElevationAtLatLng(lat, lng); err == nil {
+			elevation = p.Elevation
+		}
+		points = append(points, ProfilePoint{
+			Distance:        d,
+			Lat:             lat,
+			Lng:             lng,
+			Elevation:       elevation,
+			CurvedElevation: elevation - d*math.Atan2(d/2, EarthRadius),
+		})
+	}
+	return points, nil
+}
+
+// GreatCircleDistance returns the distance in meters between two lat/lng positions along the surface of the earth
+func GreatCircleDistance(lat0 float64, lng0 float64, lat1 float64, lng1 float64) float64 {
+	p0, p1 := unitVector(lat0, lng0), unitVector(lat1, lng1)
+	return math.Atan2(norm(cross(p0, p1)), dot(p0, p1)) * EarthRadius
+}
+
+// unitVector returns the position of lat/lng on the unit sphere
+func unitVector(lat float64, lng float64) [3]float64 {
+	phi, lambda := lat*math.Pi/180, lng*math.Pi/180
+	return [3]float64{math.Cos(phi) * math.Cos(lambda), math.Cos(phi) * math.Sin(lambda), math.Sin(phi)}
+}
+
+// latLng returns the lat/lng of a vector from the centre of the earth
+func latLng(v [3]float64) (lat float64, lng float64) {
+	return math.Atan2(v[2], math.Hypot(v[0], v[1])) * 180 / math.Pi, math.Atan2(v[1], v[0]) * 180 / math.Pi
+}
+
+func dot(a [3]float64, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a [3]float64, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func norm(a [3]float64) float64 {
+	return math.Sqrt(dot(a, a))
+}
@@ -0,0 +1,93 @@
+package dataset
+
+import (
+	"math"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	r := testReader{
+		tiles: map[string]TilePosition{
+			"coarse.dem": tileAt(32, 61, 9),
+		},
+		elevation: func(lat, lng float64) float64 {
+			if lat > 61.2 {
+				return voidElevation
+			}
+			return 1000*(lat-60) + 100*lng
+		},
+	}
+
+	em, report, cleanup := loadTestFiles(t, &r)
+	defer cleanup()
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := em.Profile(61.0, 9.1, 61.25, 9.4, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, last := points[0], points[len(points)-1]
+	if first.Lat != 61.0 || first.Lng != 9.1 || first.Distance != 0 {
+		t.Errorf("profile starts at %v", first)
+	}
+	if last.Lat != 61.25 || last.Lng != 9.4 {
+		t.Errorf("profile ends at %v", last)
+	}
+
+	// The distance is about 0.25 degrees north and 0.3 degrees east
+	if want := math.Hypot(0.25*111_200, 0.3*111_200*math.Cos(61.125*math.Pi/180)); math.Abs(last.Distance-want) > 100 {
+		t.Errorf("profile is %v meters, want %v", last.Distance, want)
+	}
+
+	for i, p := range points {
+		if i > 0 && i < len(points)-1 {
+			// The points are evenly spaced along the great circle
+			prev := points[i-1]
+			d := EarthRadius * math.Acos(dot(unitVector(prev.Lat, prev.Lng), unitVector(p.Lat, p.Lng)))
+			if math.Abs(p.Distance-prev.Distance-500) > 1e-6 || math.Abs(d-500) > 0.01 {
+				t.Errorf("point %d is %v meters from the previous point", i, d)
+			}
+		}
+
+		want, err := em.ElevationAtLatLng(p.Lat, p.Lng)
+		if _, noData := err.(*NoDataError); noData != math.IsNaN(p.Elevation) || !noData && p.Elevation != want.Elevation {
+			t.Errorf("point %d has elevation %v, want %v, %v", i, p.Elevation, want.Elevation, err)
+		}
+		if decline := p.Elevation - p.CurvedElevation; !math.IsNaN(p.Elevation) && math.Abs(decline-p.Distance*p.Distance/2/EarthRadius) > 0.01 {
+			t.Errorf("point %d is lowered %v meters at %v meters", i, decline, p.Distance)
+		}
+	}
+
+	// The end is in the sea
+	if !math.IsNaN(last.Elevation) {
+		t.Errorf("elevation in the sea is %v", last.Elevation)
+	}
+}
+
+func TestProfileErrors(t *testing.T) {
+	em, _, cleanup := loadTestFiles(t, &testReader{})
+	defer cleanup()
+
+	for _, tc := range []struct {
+		lat0, lng0, lat1, lng1, spacing float64
+	}{
+		{math.NaN(), 0, 61, 9, 100},
+		{61, 9, 61, math.Inf(1), 100},
+		{61, 9, -61, -171, 100},
+		{0, 0, 0, 180, 100},
+		{61, 9, 61.1, 9, 0},
+		{61, 9, 61.1, 9, math.NaN()},
+		{61, 9, 61.1, 9, math.Inf(1)},
+	} {
+		if points, err := em.Profile(tc.lat0, tc.lng0, tc.lat1, tc.lng1, tc.spacing); err == nil {
+			t.Errorf("profile %v has %d points", tc, len(points))
+		}
+	}
+
+	// A profile without length has one point
+	if points, err := em.Profile(61, 9, 61, 9, 100); err != nil || len(points) != 1 {
+		t.Errorf("profile without length has %d points, %v", len(points), err)
+	}
+}
@@ -0,0 +1,103 @@
+package dataset
+
+import (
+	"fmt"
+	"math"
+)
+
+// voidElevation is used for points without elevation data in utmRasters and the buffers returned by Readers.
+// It is stored as NoData.
+const voidElevation = -32767
+
+// readerAlignment is the alignment of the tiles produced from utmRasters by Readers
+const readerAlignment = 1000
+
+// utmRaster is a regular grid of elevation points in UTM coordinates with the northernmost row first
+type utmRaster struct {
+	zone int
+
+	// resolution is the distance between each elevation point in meters
+	resolution float64
+
+	// easting0/northing0 is the position of the top left elevation point
+	easting0  float64
+	northing0 float64
+
+	rows       int
+	cols       int
+	elevations []float32
+}
+
+// sample returns the elevation at the given position using bilinear interpolation between the surrounding
+// points. Positions up to one point outside the raster are clamped to the border.
+func (r *utmRaster) sample(easting float64, northing float64) float32 {
+	x := (easting - r.easting0) / r.resolution
+	y := (r.northing0 - northing) / r.resolution
+	if x < -1 || y < -1 || x > float64(r.cols) || y > float64(r.rows) {
+		return voidElevation
+	}
+
+	x = math.Max(0, math.Min(x, float64(r.cols-1)))
+	y = math.Max(0, math.Min(y, float64(r.rows-1)))
+	col, row := int(x), int(y)
+	fx, fy := x-float64(col), y-float64(row)
+	col1, row1 := col, row
+	if fx > 0 {
+		col1++
+	}
+	if fy > 0 {
+		row1++
+	}
+
+	e00 := r.elevations[row*r.cols+col]
+	e01 := r.elevations[row*r.cols+col1]
+	e10 := r.elevations[row1*r.cols+col]
+	e11 := r.elevations[row1*r.cols+col1]
+	if e00 == voidElevation || e01 == voidElevation || e10 == voidElevation || e11 == voidElevation {
+		return voidElevation
+	}
+
+	return float32((float64(e00)*(1-fx)+float64(e01)*fx)*(1-fy) +
+		(float64(e10)*(1-fx)+float64(e11)*fx)*fy)
+}
+
+// alignedPosition returns the position of the top left point of alignedBuffer. It only depends on the position
+// of the raster, so it can be used before the elevations are read.
+func (r *utmRaster) alignedPosition(alignment float64) (minEasting float64, maxNorthing float64) {
+	minEasting = math.Ceil((r.easting0-r.resolution)/alignment) * alignment
+	maxNorthing = math.Floor((r.northing0+r.resolution)/alignment) * alignment
+	return minEasting, maxNorthing
+}
+
+// tilePosition returns the position of the tile produced by a Reader from the raster. The tile has the same
+// spacing as the raster.
+func (r *utmRaster) tilePosition() TilePosition {
+	minEasting, maxNorthing := r.alignedPosition(readerAlignment)
+	return TilePosition{Zone: r.zone, MinEasting: minEasting, MaxNorthing: maxNorthing, Spacing: r.resolution}
+}
+
+// alignedBuffer returns size x size elevation points with the given resolution, starting at the first position
+// that is a multiple of alignment meters and no more than one point outside the raster.
+// Input files are not aligned to the same global grid. The top left point of USGS DEM files is typically
+// 205 meters from the aligned position, but 210 and 215 for some files. GeoTIFF files are commonly aligned
+// to the pixel corners, with points half a pixel from the aligned position.
+func (r *utmRaster) alignedBuffer(alignment float64, resolution float64, size int) (buffer [][]float32, minEasting float64, maxNorthing float64, err error) {
+	minEasting, maxNorthing = r.alignedPosition(alignment)
+
+	lastEasting := minEasting + float64(size-1)*resolution
+	lastNorthing := maxNorthing - float64(size-1)*resolution
+	if lastEasting > r.easting0+float64(r.cols)*r.resolution || lastNorthing < r.northing0-float64(r.rows)*r.resolution {
+		return nil, 0, 0, fmt.Errorf("%d x %d points from %v, %v is outside the %d x %d points in the file",
+			size, size, minEasting, maxNorthing, r.cols, r.rows)
+	}
+
+	buffer = make([][]float32, size)
+	for i := range buffer {
+		buffer[i] = make([]float32, size)
+		northing := maxNorthing - float64(i)*resolution
+		for j := range buffer[i] {
+			buffer[i][j] = r.sample(minEasting+float64(j)*resolution, northing)
+		}
+	}
+	return buffer, minEasting, maxNorthing, nil
+}
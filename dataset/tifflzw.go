@@ -0,0 +1,77 @@
+package dataset
+
+import "fmt"
+
+const (
+	lzwClear      = 256
+	lzwEndOfInput = 257
+	lzwMaxWidth   = 12
+)
+
+// decodeTIFFLZW decompresses TIFF LZW data. TIFF differs from GIF LZW (and compress/lzw) by using most
+// significant bit first codes and by increasing the code width one code earlier.
+func decodeTIFFLZW(src []byte) ([]byte, error) {
+	var (
+		// Each table entry is a string that has already been written to out
+		start  [1 << lzwMaxWidth]int
+		length [1 << lzwMaxWidth]int
+
+		out   = make([]byte, 0, 4*len(src))
+		width = 9
+		next  = lzwEndOfInput + 1
+		prev  = -1
+
+		bits  uint32
+		nBits int
+	)
+
+	for pos := 0; ; {
+		for nBits < width {
+			if pos >= len(src) {
+				// Some writers omit the end of input code
+				return out, nil
+			}
+			bits = bits<<8 | uint32(src[pos])
+			nBits += 8
+			pos++
+		}
+		code := int(bits>>uint(nBits-width)) & (1<<uint(width) - 1)
+		nBits -= width
+
+		// The previous string was written to out right before this one
+		prevStart, prevLength := 0, 0
+		if prev >= 0 {
+			prevStart, prevLength = start[prev], length[prev]
+		}
+
+		s := len(out)
+		switch {
+		case code == lzwEndOfInput:
+			return out, nil
+		case code == lzwClear:
+			width, next, prev = 9, lzwEndOfInput+1, -1
+			continue
+		case code < lzwClear:
+			out = append(out, byte(code))
+			length[code] = 1
+		case code < next:
+			out = append(out, out[start[code]:start[code]+length[code]]...)
+		case code == next && prev >= 0:
+			out = append(out, out[prevStart:prevStart+prevLength]...)
+			out = append(out, out[s])
+		default:
+			return nil, fmt.Errorf("invalid lzw code %d", code)
+		}
+
+		if prev >= 0 && next < 1<<lzwMaxWidth {
+			// The new entry is the previous string followed by the first byte of this one
+			start[next], length[next] = prevStart, prevLength+1
+			next++
+			if next >= 1<<uint(width)-1 && width < lzwMaxWidth {
+				width++
+			}
+		}
+		start[code] = s
+		prev = code
+	}
+}
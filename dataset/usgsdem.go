@@ -0,0 +1,280 @@
+package dataset
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// The USGS DEM format stores a header record (type A) followed by one record (type B) for each profile.
+// A profile is a column of elevations from south to north. Records are stored in blocks of 1024 characters
+// with fixed width fields. See https://www.usgs.gov/ for the full specification.
+const (
+	usgsDEMBlockSize = 1024
+
+	// usgsDEMReferenceUTM is the planimetric reference system code for UTM
+	usgsDEMReferenceUTM = 1
+
+	// usgsDEMUnitMeters is the unit code for meters, for both ground coordinates and elevations
+	usgsDEMUnitMeters = 2
+
+	// usgsDEMVoid is the elevation value used for points without data
+	usgsDEMVoid = -32767
+)
+
+// usgsDEMRecordReader reads fixed size blocks from a USGS DEM file.
+type usgsDEMRecordReader struct {
+	r     *bufio.Reader
+	block [usgsDEMBlockSize]byte
+}
+
+// next reads the next block. Line breaks between blocks are skipped, since some producers add them.
+func (rr *usgsDEMRecordReader) next() error {
+	for {
+		b, err := rr.r.Peek(1)
+		if err != nil {
+			return err
+		}
+		if b[0] != '\n' && b[0] != '\r' {
+			break
+		}
+		if _, err = rr.r.Discard(1); err != nil {
+			return err
+		}
+	}
+
+	_, err := io.ReadFull(rr.r, rr.block[:])
+	return err
+}
+
+// field returns the trimmed field at the given 1-based, inclusive character positions of the current block
+func (rr *usgsDEMRecordReader) field(first int, last int) string {
+	return strings.TrimSpace(string(rr.block[first-1 : last]))
+}
+
+func (rr *usgsDEMRecordReader) intField(first int, last int) (int, error) {
+	s := rr.field(first, last)
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer %q at position %d", s, first)
+	}
+	return i, nil
+}
+
+// floatField parses a fortran style floating point value, which may use D as exponent character
+func (rr *usgsDEMRecordReader) floatField(first int, last int) (float64, error) {
+	s := strings.NewReplacer("D", "E", "d", "e").Replace(rr.field(first, last))
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q at position %d", s, first)
+	}
+	return f, nil
+}
+
+// usgsDEMHeader contains the fields from the type A record needed to read the profiles
+type usgsDEMHeader struct {
+	referenceSystem int
+	zone            int
+	groundUnit      int
+	elevationUnit   int
+	xRes, yRes      float64
+	zRes            float64
+	profiles        int
+
+	// corners is the easting/northing of the south west, north west, north east and south east corners
+	corners [4][2]float64
+}
+
+func (rr *usgsDEMRecordReader) readHeader() (h usgsDEMHeader, err error) {
+	if err = rr.next(); err != nil {
+		return h, fmt.Errorf("failed to read type A record: %w", err)
+	}
+
+	for _, f := range []struct {
+		v           *int
+		first, last int
+	}{
+		{&h.referenceSystem, 157, 162},
+		{&h.zone, 163, 168},
+		{&h.groundUnit, 529, 534},
+		{&h.elevationUnit, 535, 540},
+		{&h.profiles, 859, 864},
+	} {
+		if *f.v, err = rr.intField(f.first, f.last); err != nil {
+			return h, err
+		}
+	}
+
+	for _, f := range []struct {
+		v           *float64
+		first, last int
+	}{
+		{&h.xRes, 817, 828},
+		{&h.yRes, 829, 840},
+		{&h.zRes, 841, 852},
+	} {
+		if *f.v, err = rr.floatField(f.first, f.last); err != nil {
+			return h, err
+		}
+	}
+
+	for i := range h.corners {
+		for j := range h.corners[i] {
+			first := 547 + i*48 + j*24
+			if h.corners[i][j], err = rr.floatField(first, first+23); err != nil {
+				return h, err
+			}
+		}
+	}
+
+	return h, nil
+}
+
+// usgsDEMProfile is a single type B record
+type usgsDEMProfile struct {
+	easting    float64
+	northing   float64
+	elevations []float32
+}
+
+func (rr *usgsDEMRecordReader) readProfile(h *usgsDEMHeader) (p usgsDEMProfile, err error) {
+	if err = rr.next(); err != nil {
+		return p, fmt.Errorf("failed to read type B record: %w", err)
+	}
+
+	count, err := rr.intField(13, 18)
+	if err != nil {
+		return p, err
+	}
+	if p.easting, err = rr.floatField(25, 48); err != nil {
+		return p, err
+	}
+	if p.northing, err = rr.floatField(49, 72); err != nil {
+		return p, err
+	}
+	datum, err := rr.floatField(73, 96)
+	if err != nil {
+		return p, err
+	}
+
+	// The first block has 146 elevations after the 144 character header. The following blocks have 170.
+	pos := 145
+	p.elevations = make([]float32, count)
+	for i := range p.elevations {
+		if pos+6 > usgsDEMBlockSize+1 {
+			if err = rr.next(); err != nil {
+				return p, fmt.Errorf("failed to read type B record: %w", err)
+			}
+			pos = 1
+		}
+
+		v, err := rr.intField(pos, pos+5)
+		if err != nil {
+			return p, err
+		}
+
+		if v == usgsDEMVoid {
+			p.elevations[i] = voidElevation
+		} else {
+			p.elevations[i] = float32(datum + float64(v)*h.zRes)
+		}
+		pos += 6
+	}
+
+	return p, nil
+}
+
+// readUSGSDEMHeader reads the header of a USGS DEM file, and returns a utmRaster with the position of the
+// top left point given by the corners, but without elevations
+func readUSGSDEMHeader(r io.Reader) (*utmRaster, error) {
+	rr := usgsDEMRecordReader{r: bufio.NewReaderSize(r, usgsDEMBlockSize)}
+	h, err := rr.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if err = h.check(); err != nil {
+		return nil, err
+	}
+
+	dem := utmRaster{
+		zone:       h.zone,
+		resolution: h.xRes,
+		easting0:   math.Inf(1),
+		northing0:  math.Inf(-1),
+	}
+	for _, corner := range h.corners {
+		dem.easting0 = math.Min(dem.easting0, corner[0])
+		dem.northing0 = math.Max(dem.northing0, corner[1])
+	}
+	return &dem, nil
+}
+
+// check returns an error if the file isn't supported
+func (h *usgsDEMHeader) check() error {
+	if h.referenceSystem != usgsDEMReferenceUTM {
+		return fmt.Errorf("unsupported planimetric reference system %d", h.referenceSystem)
+	}
+	if h.groundUnit != usgsDEMUnitMeters || h.elevationUnit != usgsDEMUnitMeters {
+		return fmt.Errorf("unsupported units %d/%d", h.groundUnit, h.elevationUnit)
+	}
+	if h.xRes != h.yRes || h.xRes <= 0 {
+		return fmt.Errorf("unsupported resolution %vx%v", h.xRes, h.yRes)
+	}
+	if h.profiles <= 0 {
+		return fmt.Errorf("no profiles")
+	}
+	return nil
+}
+
+// readUSGSDEM reads a USGS DEM file with UTM coordinates in meters
+func readUSGSDEM(r io.Reader) (*utmRaster, error) {
+	rr := usgsDEMRecordReader{r: bufio.NewReaderSize(r, 64*usgsDEMBlockSize)}
+
+	h, err := rr.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if err = h.check(); err != nil {
+		return nil, err
+	}
+
+	profiles := make([]usgsDEMProfile, h.profiles)
+	minNorthing, maxNorthing := math.Inf(1), math.Inf(-1)
+	for i := range profiles {
+		if profiles[i], err = rr.readProfile(&h); err != nil {
+			return nil, fmt.Errorf("profile %d: %w", i+1, err)
+		}
+		p := &profiles[i]
+		minNorthing = math.Min(minNorthing, p.northing)
+		maxNorthing = math.Max(maxNorthing, p.northing+float64(len(p.elevations)-1)*h.yRes)
+	}
+
+	dem := utmRaster{
+		zone:       h.zone,
+		resolution: h.xRes,
+		easting0:   profiles[0].easting,
+		northing0:  maxNorthing,
+		rows:       int(math.Round((maxNorthing-minNorthing)/h.yRes)) + 1,
+		cols:       len(profiles),
+	}
+
+	// Profiles may start and end at different northings. Points outside all profiles are void.
+	dem.elevations = make([]float32, dem.rows*dem.cols)
+	for i := range dem.elevations {
+		dem.elevations[i] = voidElevation
+	}
+	for col, p := range profiles {
+		if math.Abs(p.easting-(dem.easting0+float64(col)*h.xRes)) > h.xRes/2 {
+			return nil, fmt.Errorf("profile %d is not aligned with the first profile", col+1)
+		}
+		row0 := int(math.Round((maxNorthing - p.northing) / h.yRes))
+		for i, e := range p.elevations {
+			dem.elevations[(row0-i)*dem.cols+col] = e
+		}
+	}
+
+	return &dem, nil
+}
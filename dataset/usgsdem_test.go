@@ -0,0 +1,114 @@
+package dataset
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// usgsDEMWriter writes fixed width fields into 1024 character blocks
+type usgsDEMWriter struct {
+	buf   bytes.Buffer
+	block []byte
+}
+
+func (w *usgsDEMWriter) put(first int, s string) {
+	copy(w.block[first-1:], s)
+}
+
+func (w *usgsDEMWriter) putFloat(first int, width int, v float64) {
+	w.put(first, strings.Replace(fmt.Sprintf("%*.*E", width, width-9, v), "E", "D", 1))
+}
+
+func (w *usgsDEMWriter) flush() {
+	w.buf.Write(w.block)
+	w.buf.WriteByte('\n')
+	w.block = []byte(strings.Repeat(" ", usgsDEMBlockSize))
+}
+
+// writeUSGSDEM creates a USGS DEM with the given top left point and the elevation given by elevation(col, row)
+func writeUSGSDEM(zone int, res float64, easting0 float64, northing0 float64, cols int, rows int, elevation func(col, row int) int) []byte {
+	w := usgsDEMWriter{block: []byte(strings.Repeat(" ", usgsDEMBlockSize))}
+	w.put(157, fmt.Sprintf("%6d%6d", usgsDEMReferenceUTM, zone))
+	w.put(529, fmt.Sprintf("%6d%6d", usgsDEMUnitMeters, usgsDEMUnitMeters))
+	lastEasting, lastNorthing := easting0+float64(cols-1)*res, northing0-float64(rows-1)*res
+	for i, corner := range [][2]float64{{easting0, lastNorthing}, {easting0, northing0}, {lastEasting, northing0}, {lastEasting, lastNorthing}} {
+		w.putFloat(547+i*48, 24, corner[0])
+		w.putFloat(571+i*48, 24, corner[1])
+	}
+	w.putFloat(817, 12, res)
+	w.putFloat(829, 12, res)
+	w.putFloat(841, 12, 0.1)
+	w.put(853, fmt.Sprintf("%6d%6d", 1, cols))
+	w.flush()
+
+	for col := 0; col < cols; col++ {
+		w.put(1, fmt.Sprintf("%6d%6d%6d%6d", 1, col+1, rows, 1))
+		w.putFloat(25, 24, easting0+float64(col)*res)
+		w.putFloat(49, 24, northing0-float64(rows-1)*res)
+		w.putFloat(73, 24, 100)
+		pos := 145
+		for row := rows - 1; row >= 0; row-- {
+			if pos+6 > usgsDEMBlockSize+1 {
+				w.flush()
+				pos = 1
+			}
+			w.put(pos, fmt.Sprintf("%6d", elevation(col, row)))
+			pos += 6
+		}
+		w.flush()
+	}
+	return w.buf.Bytes()
+}
+
+func TestReadUSGSDEM(t *testing.T) {
+	elevation := func(col, row int) int { return col*1000 - row }
+	data := writeUSGSDEM(32, 10, 399800, 6850200, 30, 400, elevation)
+
+	dem, err := readUSGSDEM(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dem.zone != 32 || dem.resolution != 10 || dem.cols != 30 || dem.rows != 400 {
+		t.Fatalf("unexpected header %+v", *dem)
+	}
+
+	if dem.easting0 != 399800 || dem.northing0 != 6850200 {
+		t.Fatalf("unexpected position %v, %v", dem.easting0, dem.northing0)
+	}
+
+	for _, p := range [][2]int{{0, 0}, {3, 145}, {17, 146}, {29, 399}} {
+		want := float32(100 + float64(elevation(p[0], p[1]))*0.1)
+		if got := dem.elevations[p[1]*dem.cols+p[0]]; got != want {
+			t.Errorf("elevation at %v is %v, want %v", p, got, want)
+		}
+	}
+
+	buf, minEasting, maxNorthing, err := dem.alignedBuffer(100, 10, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if minEasting != 399800 || maxNorthing != 6850200 || buf[4][2] != dem.elevations[4*dem.cols+2] {
+		t.Errorf("unexpected aligned buffer at %v, %v", minEasting, maxNorthing)
+	}
+
+	buf, minEasting, maxNorthing, err = dem.alignedBuffer(1000, 10, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if minEasting != 400000 || maxNorthing != 6850000 || buf[0][0] != dem.elevations[20*dem.cols+20] {
+		t.Errorf("unexpected aligned buffer at %v, %v", minEasting, maxNorthing)
+	}
+	// The position of the aligned buffer is known from the header
+	header, err := readUSGSDEMHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, n := header.alignedPosition(1000); e != minEasting || n != maxNorthing || header.zone != 32 {
+		t.Errorf("unexpected position %v, %v in zone %d from header", e, n, header.zone)
+	}
+}
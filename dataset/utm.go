@@ -0,0 +1,126 @@
+package dataset
+
+import "math"
+
+// Constants for the WGS 84 ellipsoid and the UTM projection
+const (
+	wgs84SemiMajorAxis = 6_378_137.0
+	wgs84Flattening    = 1 / 298.257223563
+
+	utmScaleFactor   = 0.9996
+	utmFalseEasting  = 500_000.0
+	utmFalseNorthing = 0.0
+)
+
+// transverseMercator implements the Transverse Mercator projection using the Krüger series (to third order in n).
+// This is accurate to well within a millimetre inside a UTM zone.
+type transverseMercator struct {
+	centralMeridian float64 // radians
+
+	e     float64    // eccentricity
+	a     float64    // rectifying radius multiplied by the scale factor
+	alpha [3]float64 // coefficients for the forward series
+	beta  [3]float64 // coefficients for the inverse series
+	delta [3]float64 // coefficients for conformal latitude to latitude
+}
+
+func newTransverseMercator(centralMeridianDegrees float64) transverseMercator {
+	f := wgs84Flattening
+	n := f / (2 - f)
+	n2 := n * n
+	n3 := n2 * n
+
+	return transverseMercator{
+		centralMeridian: centralMeridianDegrees * math.Pi / 180,
+		e:               math.Sqrt(f * (2 - f)),
+		a:               utmScaleFactor * wgs84SemiMajorAxis / (1 + n) * (1 + n2/4 + n2*n2/64),
+		alpha: [3]float64{
+			n/2 - 2*n2/3 + 5*n3/16,
+			13*n2/48 - 3*n3/5,
+			61 * n3 / 240,
+		},
+		beta: [3]float64{
+			n/2 - 2*n2/3 + 37*n3/96,
+			n2/48 + n3/15,
+			17 * n3 / 480,
+		},
+		delta: [3]float64{
+			2*n - 2*n2/3 - 2*n3,
+			7*n2/3 - 8*n3/5,
+			56 * n3 / 15,
+		},
+	}
+}
+
+// forward projects lat/lng (degrees) to easting/northing (meters)
+func (tm *transverseMercator) forward(lat float64, lng float64) (easting float64, northing float64) {
+	phi := lat * math.Pi / 180
+	lambda := lng*math.Pi/180 - tm.centralMeridian
+
+	sinPhi := math.Sin(phi)
+	t := math.Sinh(math.Atanh(sinPhi) - tm.e*math.Atanh(tm.e*sinPhi))
+	xi := math.Atan2(t, math.Cos(lambda))
+	eta := math.Atanh(math.Sin(lambda) / math.Sqrt(1+t*t))
+
+	x, y := eta, xi
+	for j, alpha := range tm.alpha {
+		k := 2 * float64(j+1)
+		x += alpha * math.Cos(k*xi) * math.Sinh(k*eta)
+		y += alpha * math.Sin(k*xi) * math.Cosh(k*eta)
+	}
+
+	return utmFalseEasting + tm.a*x, utmFalseNorthing + tm.a*y
+}
+
+// inverse projects easting/northing (meters) to lat/lng (degrees)
+func (tm *transverseMercator) inverse(easting float64, northing float64) (lat float64, lng float64) {
+	xi := (northing - utmFalseNorthing) / tm.a
+	eta := (easting - utmFalseEasting) / tm.a
+
+	xi1, eta1 := xi, eta
+	for j, beta := range tm.beta {
+		k := 2 * float64(j+1)
+		xi1 -= beta * math.Sin(k*xi) * math.Cosh(k*eta)
+		eta1 -= beta * math.Cos(k*xi) * math.Sinh(k*eta)
+	}
+
+	chi := math.Asin(math.Sin(xi1) / math.Cosh(eta1))
+	phi := chi
+	for j, delta := range tm.delta {
+		phi += delta * math.Sin(2*float64(j+1)*chi)
+	}
+	lambda := tm.centralMeridian + math.Atan2(math.Sinh(eta1), math.Cos(xi1))
+
+	return phi * 180 / math.Pi, lambda * 180 / math.Pi
+}
+
+// zoneCentralMeridian returns the central meridian of the given UTM zone in degrees
+func zoneCentralMeridian(zone int) float64 {
+	return float64(zone*6 - 183)
+}
+
+// utmZone returns the UTM zone for the given lat/lng, including the exceptions for south western Norway and
+// Svalbard
+func utmZone(lat float64, lng float64) int {
+	switch {
+	case lat >= 56 && lat < 64 && lng >= 3 && lng < 12:
+		return 32
+	case lat >= 72 && lat < 84 && lng >= 0 && lng < 42:
+		switch {
+		case lng < 9:
+			return 31
+		case lng < 21:
+			return 33
+		case lng < 33:
+			return 35
+		default:
+			return 37
+		}
+	}
+
+	zone := int((lng+180)/6) + 1
+	if zone > 60 {
+		zone = 60
+	}
+	return zone
+}
@@ -0,0 +1,56 @@
+package dataset
+
+import (
+	"math"
+	"testing"
+)
+
+// utmControlPoints are published coordinates. Easting/northing are compared within the precision they are published
+// with. The zones in the southern hemisphere have a false northing of 10 000 000 meters, which UTM leaves out.
+//
+// Flinders Peak and Buninyong are the worked examples in the Geocentric Datum of Australia Technical Manual by ICSM.
+// They are on GRS80, which differs from WGS 84 by much less than a millimetre here. The last point is the example in
+// the documentation of GeoConvert from GeographicLib.
+var utmControlPoints = []struct {
+	zone              int
+	lat, lng          float64
+	easting, northing float64
+	falseNorthing     float64
+	precision         float64
+}{
+	{55, -(37 + 57/60.0 + 3.7203/3600), 144 + 25/60.0 + 29.5244/3600, 273741.297, 5796489.777, 10_000_000, 0.001},
+	{55, -(37 + 39/60.0 + 10.1561/3600), 143 + 55/60.0 + 35.3839/3600, 228854.052, 5828259.038, 10_000_000, 0.001},
+	{38, 33.3, 44.4, 444140.54, 3684706.36, 0, 0.01},
+}
+
+func TestLatLngToUTM(t *testing.T) {
+	for _, p := range utmControlPoints {
+		utm := NewUTM(p.zone)
+		easting, northing := utm.LatLngToUTM(p.lat, p.lng)
+		northing += p.falseNorthing
+		if math.Abs(easting-p.easting) > p.precision || math.Abs(northing-p.northing) > p.precision {
+			t.Errorf("LatLngToUTM(%v, %v) = %.4f, %.4f, want %.4f, %.4f",
+				p.lat, p.lng, easting, northing, p.easting, p.northing)
+		}
+	}
+}
+
+func TestUTMToLatLng(t *testing.T) {
+	for _, p := range utmControlPoints {
+		utm := NewUTM(p.zone)
+		lat, lng := utm.UTMToLatLng(p.easting, p.northing-p.falseNorthing)
+
+		// 1e-7 degrees is about one centimetre
+		if math.Abs(lat-p.lat) > 1e-7 || math.Abs(lng-p.lng) > 1e-7 {
+			t.Errorf("UTMToLatLng(%.4f, %.4f) = %v, %v, want %v, %v",
+				p.easting, p.northing, lat, lng, p.lat, p.lng)
+		}
+
+		easting, northing := utm.LatLngToUTM(lat, lng)
+		northing += p.falseNorthing
+		if math.Abs(easting-p.easting) > 0.001 || math.Abs(northing-p.northing) > 0.001 {
+			t.Errorf("round trip of %.4f, %.4f gives %.4f, %.4f",
+				p.easting, p.northing, easting, northing)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package dataset
+
+import (
+	"math"
+	"sort"
+)
+
+// foreignMax is kept in the grid for each ElevationMaplet resampled from tiles in other UTM zones. The maplets
+// themselves are kept in the maplet cache, and resampled again when they are needed after they are removed from it.
+type foreignMax struct {
+	maxElevation Elevation16
+
+	// empty is set if there are no tiles for the maplet in any zone
+	empty bool
+}
+
+// grid returns the grid of the tiles with the given zone and spacing
+func (t *elevationTiles) grid(zone int, spacing float64) *zoneGrid {
+	return t.levelGrid(zone, spacing, 0)
+}
+
+// levelGrid returns the grid for the given level of the tiles with the given zone and spacing
+func (t *elevationTiles) levelGrid(zone int, spacing float64, level int) *zoneGrid {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	key := gridKey{zone: zone, spacing: spacing, level: level}
+	g, ok := t.grids[key]
+	if !ok {
+		g = &zoneGrid{zone: zone, spacing: spacing, level: level, tiles: t}
+		t.grids[key] = g
+	}
+	return g
+}
+
+// elevationAt returns the elevation at the given easting/northing from the tiles in the grid, using
+// bilinear interpolation. It returns NaN if any of the surrounding points is NoData, and false if there is no tile
+// for the position.
+func (g *zoneGrid) elevationAt(easting float64, northing float64) (float64, bool) {
+	x := (easting - gridMinEasting) / g.spacing
+	y := (gridMaxNorthing - northing) / g.spacing
+	e, n := IntStep(math.Floor(x)), IntStep(math.Floor(y))
+	fx, fy := x-float64(e), y-float64(n)
+
+	var elevations [2][2]float64
+	for i := IntStep(0); i < 2; i++ {
+		for j := IntStep(0); j < 2; j++ {
+			if e+j < 0 || n+i < 0 {
+				return 0, false
+			}
+			mmapStruct := g.lookupMmapStruct(int((e+j)/bigSquareSize), int((n+i)/bigSquareSize))
+			if mmapStruct == nil {
+				return 0, false
+			}
+			maplet := mmapStruct.maplet(index2(n+i), index2(e+j))
+			if maplet == nil {
+				return 0, false
+			}
+			elevation := maplet[(n+i)%ElevationMapletSize][(e+j)%ElevationMapletSize]
+			if elevation == NoData {
+				return math.NaN(), true
+			}
+			elevations[i][j] = float64(elevation)
+		}
+	}
+
+	return ((elevations[0][0]*(1-fx)+elevations[0][1]*fx)*(1-fy) +
+		(elevations[1][0]*(1-fx)+elevations[1][1]*fx)*fy) * Elevation16Unit, true
+}
+
+// hasForeignTiles returns true if tiles in other zones may cover the tile with the given tile indices in the grid
+func (g *zoneGrid) hasForeignTiles(e int, n int) bool {
+	size := g.spacing * bigSquareSize
+	utm := NewUTM(g.zone)
+	for _, zone := range g.tiles.zones {
+		if zone == g.zone {
+			continue
+		}
+
+		// The tiles within the bounding box of the corners in the other zone are checked
+		other := NewUTM(zone)
+		minE, minN, maxE, maxN := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 2; j++ {
+				lat, lng := utm.UTMToLatLng(gridMinEasting+float64(e+j)*size, gridMaxNorthing-float64(n+i)*size)
+				easting, northing := other.LatLngToUTM(lat, lng)
+				minE, maxE = math.Min(minE, easting), math.Max(maxE, easting)
+				minN, maxN = math.Min(minN, northing), math.Max(maxN, northing)
+			}
+		}
+		for te := int(math.Floor((minE - gridMinEasting) / size)); float64(te)*size < maxE-gridMinEasting; te++ {
+			for tn := int(math.Floor((gridMaxNorthing - maxN) / size)); float64(tn)*size < gridMaxNorthing-minN; tn++ {
+				if _, ok := g.tiles.index[tileIndex{zone: zone, spacing: g.spacing, e: te, n: tn}]; ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// foreignMaplet returns the maplet at the given position, resampled from tiles in other zones, or nil if there are
+// no tiles for it in any zone
+func (em *ElevationMap) foreignMaplet(e IntStep, n IntStep) *ElevationMaplet {
+	key := mapletKey{grid: em.grid, i: int(n / ElevationMapletSize), j: int(e / ElevationMapletSize)}
+	if f, ok := em.grid.foreign.Load(key); ok && f.(foreignMax).empty {
+		return nil
+	}
+	if maplet := em.tiles.maplets.get(key); maplet != nil {
+		return maplet
+	}
+
+	maplet, maxElevation := em.resampleForeignMaplet(IntStep(key.j), IntStep(key.i))
+	em.grid.foreign.Store(key, foreignMax{maxElevation: maxElevation, empty: maplet == nil})
+	if maplet == nil {
+		return nil
+	}
+	return em.tiles.maplets.add(key, maplet)
+}
+
+// foreignMaxElevation returns the maximum elevation of the maplet at the given position, resampled from tiles in
+// other zones, or -1 if there are no tiles for it in any zone. The maplet is only resampled the first time.
+func (em *ElevationMap) foreignMaxElevation(e IntStep, n IntStep) float64 {
+	key := mapletKey{grid: em.grid, i: int(n / ElevationMapletSize), j: int(e / ElevationMapletSize)}
+	f, ok := em.grid.foreign.Load(key)
+	if !ok {
+		em.foreignMaplet(e, n)
+		f, _ = em.grid.foreign.Load(key)
+	}
+	if f.(foreignMax).empty {
+		return -1
+	}
+	return float64(f.(foreignMax).maxElevation) * Elevation16Unit
+}
+
+// resampleForeignMaplet builds the maplet with the given maplet indices from tiles in other zones, and returns it
+// with the maximum elevation. The maplet is nil if there are no tiles for it in any zone. Zones closer to the grid
+// zone are preferred where tiles overlap.
+func (em *ElevationMap) resampleForeignMaplet(mapletE IntStep, mapletN IntStep) (*ElevationMaplet, Elevation16) {
+	g := em.grid
+	size := ElevationMapletSize * g.spacing
+	utm := NewUTM(g.zone)
+	easting0 := gridMinEasting + float64(mapletE)*size
+	northing0 := gridMaxNorthing - float64(mapletN)*size
+
+	zones := append([]int{}, em.tiles.zones...)
+	sort.SliceStable(zones, func(i, j int) bool {
+		return math.Abs(float64(zones[i]-g.zone)) < math.Abs(float64(zones[j]-g.zone))
+	})
+
+	// The transformation between zones is smooth, so the corners are transformed and interpolated in between
+	type candidate struct {
+		grid    *zoneGrid
+		corners [2][2][2]float64
+	}
+	var candidates []candidate
+	for _, zone := range zones {
+		if zone == g.zone {
+			continue
+		}
+
+		c := candidate{grid: em.tiles.grid(zone, g.spacing)}
+		other := NewUTM(zone)
+		covered := false
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 2; j++ {
+				lat, lng := utm.UTMToLatLng(easting0+float64(j)*size, northing0-float64(i)*size)
+				c.corners[i][j][0], c.corners[i][j][1] = other.LatLngToUTM(lat, lng)
+				covered = covered || c.grid.hasTile(c.corners[i][j][0], c.corners[i][j][1])
+			}
+		}
+		if covered {
+			candidates = append(candidates, c)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, 0
+	}
+
+	// The extra row and column are included in maxElevation, like for the tiles
+	var maplet ElevationMaplet
+	var maxElevation Elevation16
+	for row := 0; row <= ElevationMapletSize; row++ {
+		fy := float64(row) / ElevationMapletSize
+		for col := 0; col <= ElevationMapletSize; col++ {
+			fx := float64(col) / ElevationMapletSize
+
+			// Points that are not covered by any tile have no data
+			elevation := math.NaN()
+			for _, c := range candidates {
+				var pos [2]float64
+				for k := range pos {
+					pos[k] = (c.corners[0][0][k]*(1-fx)+c.corners[0][1][k]*fx)*(1-fy) +
+						(c.corners[1][0][k]*(1-fx)+c.corners[1][1][k]*fx)*fy
+				}
+				if v, ok := c.grid.elevationAt(pos[0], pos[1]); ok {
+					elevation = v
+					break
+				}
+			}
+
+			intVal := toElevation16(elevation)
+			if row < ElevationMapletSize && col < ElevationMapletSize {
+				maplet[row][col] = intVal
+			}
+			if intVal > maxElevation {
+				maxElevation = intVal
+			}
+		}
+	}
+	return &maplet, maxElevation
+}
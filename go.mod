@@ -2,7 +2,4 @@ module github.com/larschri/blaneblikk
 
 go 1.15
 
-require (
-	github.com/lucasb-eyer/go-colorful v1.0.3
-	github.com/lukeroth/gdal v0.0.0-20210429104814-0ed96ec28fb2
-)
+require github.com/lucasb-eyer/go-colorful v1.0.3
@@ -0,0 +1,125 @@
+// Package terraintest provides synthetic terrain for tests. The tiles are generated the first time they are used,
+// in a temporary directory that is removed by Main.
+package terraintest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/larschri/blaneblikk/dataset"
+)
+
+// The terrain is 4x4 tiles of 50x50 km around Easting/Northing in UTM zone 32
+const (
+	TileSize = 50_000
+	Easting  = 450_000
+	Northing = 6_800_000
+)
+
+// Reader produces tiles with 10 meters spacing and the terrain given by Elevation. The files are named by the
+// position of the tiles.
+type Reader struct{}
+
+// Locate returns the position of the tile from the file name
+func (Reader) Locate(fname string) (dataset.TilePosition, error) {
+	tile := dataset.TilePosition{Zone: 32, Spacing: dataset.Unit}
+	if _, err := fmt.Sscanf(filepath.Base(fname), "%f %f", &tile.MinEasting, &tile.MaxNorthing); err != nil {
+		return tile, errors.New("corrupt file")
+	}
+	return tile, nil
+}
+
+// ReadFile returns the elevations of the tile
+func (r Reader) ReadFile(fname string) ([][]float32, dataset.TilePosition, error) {
+	tile, err := r.Locate(fname)
+	if err != nil {
+		return nil, tile, err
+	}
+
+	const size = TileSize/dataset.Unit + 1
+	buffer := make([][]float32, size)
+	for i := range buffer {
+		buffer[i] = make([]float32, size)
+		northing := tile.MaxNorthing - float64(i)*dataset.Unit
+		for j := range buffer[i] {
+			buffer[i][j] = float32(Elevation(tile.MinEasting+float64(j)*dataset.Unit, northing))
+		}
+	}
+	return buffer, tile, nil
+}
+
+// Elevation is hills and valleys with a few sizes, and sea in the north east
+func Elevation(easting float64, northing float64) float64 {
+	elevation := 600 +
+		400*math.Sin(easting/7000)*math.Cos(northing/9000) +
+		150*math.Sin(easting/1300+northing/2100) +
+		30*math.Cos(easting/170)*math.Sin(northing/230) -
+		(easting+northing-Easting-Northing)/100
+	if elevation < 0 {
+		return -32767
+	}
+	return elevation
+}
+
+var (
+	once         sync.Once
+	dir          string
+	elevationMap dataset.ElevationMap
+	loadErr      error
+)
+
+// Load loads the terrain. The tiles are read and mapped when they are first used.
+func Load(tb testing.TB) dataset.ElevationMap {
+	once.Do(func() {
+		var tempDir string
+		if tempDir, loadErr = ioutil.TempDir("", "blaneblikk-terrain"); loadErr != nil {
+			return
+		}
+		dir = tempDir
+
+		var fNames []string
+		for i := -2; i < 2; i++ {
+			for j := -2; j < 2; j++ {
+				fName := filepath.Join(dir, fmt.Sprintf("%d %d", Easting+i*TileSize, Northing+(j+1)*TileSize))
+				if loadErr = ioutil.WriteFile(fName, nil, 0644); loadErr != nil {
+					return
+				}
+				fNames = append(fNames, fName)
+			}
+		}
+
+		var report dataset.LoadReport
+		elevationMap, report, loadErr = dataset.LoadFiles(Reader{}, dir, fNames, dataset.LoadOptions{})
+		if loadErr == nil {
+			loadErr = report.Err()
+		}
+	})
+	if loadErr != nil {
+		tb.Fatal(loadErr)
+	}
+	return elevationMap
+}
+
+// cleanup closes the terrain and removes the temporary directory, if the terrain was loaded
+func cleanup() {
+	if dir == "" {
+		return
+	}
+	if loadErr == nil {
+		elevationMap.Close()
+	}
+	os.RemoveAll(dir)
+}
+
+// Main runs the tests and removes the terrain. It is called from TestMain in packages using the terrain.
+func Main(m *testing.M) {
+	code := m.Run()
+	cleanup()
+	os.Exit(code)
+}
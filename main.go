@@ -3,41 +3,172 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/larschri/blaneblikk/dataset"
 	"github.com/larschri/blaneblikk/server"
 )
 
-func newServer(demFileDir, mmapFileDir, hostPort string) (*server.Server, error) {
-	files, err := filepath.Glob(demFileDir + "/[^.]*.dem")
+// readers is used to read elevation files, selected by file name extension
+var readers = dataset.ReaderByExtension{
+	".dem":  &dataset.DTM10UTM32Dataset,
+	".tif":  &dataset.GeoTIFF{},
+	".tiff": &dataset.GeoTIFF{},
+	".hgt":  &dataset.HGT{},
+}
+
+// loader loads the elevation files in a directory
+type loader struct {
+	demFileDir  string
+	mmapFileDir string
+
+	// strict makes load fail if any file can't be loaded
+	strict  bool
+	options dataset.LoadOptions
+}
+
+// files returns the elevation files in the directory, sorted by name
+func (l *loader) files() ([]string, error) {
+	var files []string
+	for ext := range readers {
+		f, err := filepath.Glob(l.demFileDir + "/[^.]*" + ext)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// load loads the elevation files. Files that can't be loaded are logged, or returned as an error if strict is set.
+func (l *loader) load() (dataset.ElevationMap, error) {
+	files, err := l.files()
 	if err != nil {
-		return nil, err
+		return dataset.ElevationMap{}, err
+	}
+
+	elevationMap, report, err := dataset.LoadFiles(readers, l.mmapFileDir, files, l.options)
+	if err != nil {
+		return dataset.ElevationMap{}, err
+	}
+
+	for _, f := range report {
+		for _, reason := range f.Skipped {
+			log.Printf("skipped %s: %v", f.FName, reason)
+		}
+	}
+	if err = report.Err(); err != nil && l.strict {
+		elevationMap.Close()
+		return dataset.ElevationMap{}, err
+	}
+	return elevationMap, nil
+}
+
+// snapshot returns a description of the names, sizes and modification times of the elevation files
+func (l *loader) snapshot() (string, error) {
+	files, err := l.files()
+	if err != nil {
+		return "", err
 	}
 
-	elevationMap, err := dataset.LoadFiles(&dataset.DTM10UTM32Dataset, mmapFileDir, files)
+	var sb strings.Builder
+	for _, f := range files {
+		fileInfo, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s %d %d\n", f, fileInfo.Size(), fileInfo.ModTime().UnixNano())
+	}
+	return sb.String(), nil
+}
+
+func newServer(l *loader, hostPort string) (*server.Server, error) {
+	elevationMap, err := l.load()
 	if err != nil {
 		return nil, err
 	}
 
 	listener, err := net.Listen("tcp", hostPort)
 	if err != nil {
+		elevationMap.Close()
 		return nil, err
 	}
 	log.Print("Listening to " + listener.Addr().String())
 
 	return &server.Server{
-		ElevationMap: elevationMap,
-		Listener:     listener,
+		ElevationMap:     elevationMap,
+		Listener:         listener,
+		LoadElevationMap: l.load,
 	}, nil
 
 }
 
+// reloadOnHangup reloads the server when the process receives SIGHUP, until the context is cancelled
+func reloadOnHangup(ctx context.Context, s *server.Server) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+			if err := s.Reload(); err != nil {
+				log.Printf("reload failed: %v", err)
+			}
+		}
+	}
+}
+
+// watch reloads the server when the elevation files have changed, until the context is cancelled. The files are
+// checked at the given interval, and must be unchanged for one interval before reloading. This avoids loading
+// files that are still being downloaded.
+func watch(ctx context.Context, l *loader, s *server.Server, interval time.Duration) {
+	loaded, err := l.snapshot()
+	if err != nil {
+		log.Printf("failed to watch %s: %v", l.demFileDir, err)
+		return
+	}
+	previous := loaded
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current, err := l.snapshot()
+		if err != nil {
+			log.Printf("failed to watch %s: %v", l.demFileDir, err)
+			continue
+		}
+
+		if current == previous && current != loaded {
+			log.Printf("elevation files in %s changed", l.demFileDir)
+			if err = s.Reload(); err != nil {
+				log.Printf("reload failed: %v", err)
+			}
+			loaded = current
+		}
+		previous = current
+	}
+}
+
 // withCancelOnSignal create a context that is cancelled when the process is interrupted.
 // The parent parameter is used as the parent context.
 func withCancelOnInterrupt(parent context.Context) context.Context {
@@ -56,17 +187,41 @@ func withCancelOnInterrupt(parent context.Context) context.Context {
 
 func main() {
 	hostPort := flag.String("address", "localhost:8090", "http 'host:port' for the server")
-	demFileDir := flag.String("demfiles", "dem-files", "directory with *.dem files")
+	demFileDir := flag.String("demfiles", "dem-files", "directory with elevation files (*.dem, *.tif, *.hgt)")
 	mmapFileDir := flag.String("mmapfiles", "/tmp", "directory for generated (optimised) *.mmap files")
+	strict := flag.Bool("strict", false, "exit if any elevation file can't be loaded")
+	verify := flag.Bool("verify", false, "verify checksums of the *.mmap files, and regenerate them on mismatch")
+	watchInterval := flag.Duration("watch", 0, "interval for checking for changed elevation files (0 disables)")
+	maxTiles := flag.Int("maxtiles", 0, "maximum number of tiles mapped into memory at the same time (0 is unlimited)")
+	compress := flag.Bool("compress", false, "use compressed *.cmap files instead of *.mmap files")
+	mapletCache := flag.Int("mapletcache", 0, "number of decompressed, downsampled or resampled 80 kB maplets kept in memory (0 is 1024)")
+	adminReload := flag.Bool("adminreload", false, "serve POST /admin/reload without authentication (only for trusted clients)")
 	flag.Parse()
 
-	s, err := newServer(*demFileDir, *mmapFileDir, *hostPort)
+	l := loader{
+		demFileDir:  *demFileDir,
+		mmapFileDir: *mmapFileDir,
+		strict:      *strict,
+		options: dataset.LoadOptions{
+			Verify:          *verify,
+			MaxMappedTiles:  *maxTiles,
+			Compress:        *compress,
+			MapletCacheSize: *mapletCache,
+		},
+	}
+	s, err := newServer(&l, *hostPort)
 	if err != nil {
 		panic(err)
 	}
+	s.AdminReload = *adminReload
 
 	ctx := withCancelOnInterrupt(context.Background())
 
+	go reloadOnHangup(ctx, s)
+	if *watchInterval > 0 {
+		go watch(ctx, &l, s, *watchInterval)
+	}
+
 	if err = s.Serve(ctx); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,74 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// compassRows is the height of the compass scale below a panorama
+const compassRows = 20
+
+var (
+	compassBackground = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	compassForeground = color.RGBA{R: 60, G: 60, B: 60, A: 255}
+)
+
+// compassLetters are 5x7 bitmaps of the letters used for the compass points
+var compassLetters = map[rune][7]string{
+	'N': {"X...X", "XX..X", "X.X.X", "X..XX", "X...X", "X...X", "X...X"},
+	'E': {"XXXXX", "X....", "X....", "XXXX.", "X....", "X....", "XXXXX"},
+	'S': {".XXXX", "X....", "X....", ".XXX.", "....X", "....X", "XXXX."},
+	'W': {"X...X", "X...X", "X...X", "X.X.X", "X.X.X", "XX.XX", "X...X"},
+}
+
+// compassPoints are the names of the directions with labels, clockwise from north
+var compassPoints = []string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+
+// drawCompass draws the compass scale in the rows from top and down. There is a tick for every 10 degrees, and a
+// longer tick and a label for each of the compassPoints.
+func (r Renderer) drawCompass(img *image.RGBA, top int) {
+	for y := top; y < top+compassRows; y++ {
+		for x := 0; x < r.Columns; x++ {
+			img.Set(x, y, compassBackground)
+		}
+	}
+
+	for degrees := 0; degrees < 360; degrees += 10 {
+		x := r.compassColumn(float64(degrees) * math.Pi / 180)
+		length := 3
+		if degrees%45 == 0 {
+			length = 6
+		}
+		for y := top; y < top+length; y++ {
+			img.Set(x, y, compassForeground)
+		}
+	}
+
+	for i, name := range compassPoints {
+		x := r.compassColumn(float64(i) * math.Pi / 4)
+		drawLabel(img, x-len(name)*3+1, top+9, name)
+	}
+}
+
+// compassColumn returns the column of the panorama in the given direction
+func (r Renderer) compassColumn(rad float64) int {
+	x := int(math.Round((rad - r.Start) / (2 * math.Pi) * float64(r.Columns)))
+	return ((x % r.Columns) + r.Columns) % r.Columns
+}
+
+// drawLabel draws the text with compassLetters, with the top left corner at x/y. Letters are one pixel apart. The
+// text wraps around from the right side of the image to the left side.
+func drawLabel(img *image.RGBA, x int, y int, text string) {
+	width := img.Bounds().Dx()
+	for _, letter := range text {
+		for i, row := range compassLetters[letter] {
+			for j, pixel := range row {
+				if pixel == 'X' {
+					img.Set(((x+j)%width+width)%width, y+i, compassForeground)
+				}
+			}
+		}
+		x += 6
+	}
+}
@@ -0,0 +1,100 @@
+package render
+
+import (
+	"math"
+
+	"github.com/larschri/blaneblikk/transform"
+)
+
+// Parameters for AutoFrame. The terrain is found by tracing a few columns with coarse geo pixels, in a vertical
+// window that is large enough for any view.
+const (
+	frameColumns     = 64
+	frameGeoPixels   = 900
+	frameBottomAngle = -math.Pi / 4
+	frameHeightAngle = math.Pi / 2
+
+	// frameMinDistance is the distance to the nearest terrain that is fitted in the view. The ground closer to the
+	// viewpoint is far below the horizon from summits.
+	frameMinDistance = 1000.0
+
+	// frameMargin is the margin above and below the terrain, relative to the vertical angle of the terrain
+	frameMargin = 0.1
+
+	// minFrameRows and maxFrameRows limit the rows chosen by AutoFrame, relative to the number of columns
+	minFrameRows = 0.1
+	maxFrameRows = 1.0
+)
+
+// frame returns the Renderer with the vertical window chosen if AutoFrame is set. The terrain from frameMinDistance
+// and up to the skyline is fitted in the view with a margin. Rows is chosen if it is 0, up to MaxRows, otherwise only
+// Tilt. The skyline is kept in the view when the terrain doesn't fit.
+func (r Renderer) frame() Renderer {
+	if !r.AutoFrame {
+		return r
+	}
+	r.AutoFrame = false
+
+	low, high, ok := r.terrainAngles()
+	if !ok {
+		return r
+	}
+	margin := (high - low) * frameMargin
+	low, high = low-margin, high+margin
+
+	if r.Rows == 0 {
+		rows := (high - low) * float64(r.Columns) / r.Width
+		rows = math.Max(rows, minFrameRows*float64(r.Columns))
+		rows = math.Min(rows, maxFrameRows*float64(r.Columns))
+		if r.MaxRows > 0 {
+			rows = math.Min(rows, float64(r.MaxRows))
+		}
+		r.Rows = int(math.Ceil(rows))
+	}
+
+	heightAngle := r.HeightAngle()
+	bottom := high - heightAngle
+	if heightAngle > high-low {
+		bottom = (low + high - heightAngle) / 2
+	}
+	r.Tilt = bottom + heightAngle/2 - transform.DefaultPitch(heightAngle)
+	return r
+}
+
+// terrainAngles returns the lowest and highest angle above the horizontal of the terrain from frameMinDistance and up
+// to the skyline, in some of the columns of the view. It returns false if there is no terrain.
+func (r Renderer) terrainAngles() (low float64, high float64, ok bool) {
+	probe := r.transform()
+	probe.GeoPixelLen = frameGeoPixels
+	probe.HeightAngle = frameHeightAngle
+	probe.Tilt = frameBottomAngle + frameHeightAngle/2 - transform.DefaultPitch(frameHeightAngle)
+	probe.Interpolation = 0
+	probe.Projection = transform.Equirectangular
+	angleStep := frameHeightAngle / frameGeoPixels
+
+	columns := frameColumns
+	if r.Columns < columns {
+		columns = r.Columns
+	}
+
+	low, high = math.Inf(1), math.Inf(-1)
+	pixels := make([]transform.GeoPixel, 0, frameGeoPixels)
+	for i := 0; i < columns; i++ {
+		rad := r.Start + (float64(i)+0.5)*r.Width/float64(columns)
+		geoPixels := probe.TraceDirection(rad, pixels[:0])
+		if len(geoPixels) == 0 {
+			continue
+		}
+
+		// The terrain may be closer than frameMinDistance all the way up to the skyline
+		minDistance := math.Min(frameMinDistance, geoPixels[len(geoPixels)-1].Distance)
+		for j, pixel := range geoPixels {
+			if pixel.Distance >= minDistance {
+				low = math.Min(low, frameBottomAngle+float64(j)*angleStep)
+				break
+			}
+		}
+		high = math.Max(high, frameBottomAngle+float64(len(geoPixels))*angleStep)
+	}
+	return low, high, low < high
+}
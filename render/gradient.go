@@ -9,6 +9,9 @@ type gradient struct {
 	gradient [][]rgb
 }
 
+// water is used for pixels without elevation data
+var water gradient
+
 var gradient1 = gradient{
 	gradient: [][]rgb{
 		{green, black},
@@ -40,6 +43,11 @@ func init() {
 	g = append(g, hcl2(270, 0.45, 1.4))
 	g = append(g, hcl2(280, 0.45, 1.5))
 	gradient1.gradient = g
+
+	// Water is blue, and fades with distance like the terrain
+	for i := range g {
+		water.gradient = append(water.gradient, hcl2(250, 0.3, 0.7+0.07*float64(i)))
+	}
 }
 
 func intAndFraction(value float64, max float64, length int) (int, float64) {
@@ -57,6 +65,14 @@ func intAndFraction(value float64, max float64, length int) (int, float64) {
 	return i, r - float64(i)
 }
 
+// pixelRGB returns the color of the pixel, using the water gradient for pixels without elevation data
+func pixelRGB(b transform.GeoPixel) rgb {
+	if b.NoData {
+		return water.getRGB(b)
+	}
+	return gradient1.getRGB(b)
+}
+
 func (g gradient) getRGB(b transform.GeoPixel) rgb {
 
 	id, rd := intAndFraction(b.Distance, 200_000, len(g.gradient))
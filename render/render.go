@@ -6,40 +6,99 @@ import (
 	"github.com/larschri/blaneblikk/transform"
 	"image"
 	"math"
+	"runtime"
+	"sync"
 )
 
 // Renderer contains parameters to render a view
 type Renderer struct {
-	Start      float64
-	Width      float64
-	Columns    int
+	// Start is the direction of the left side of the view, and Width is the horizontal angle of the view, in radians
+	Start   float64
+	Width   float64
+	Columns int
+
+	// Rows is the height of the image in pixels. The pixels are square, so this also gives the vertical angle of the
+	// view. The default gives the vertical angle transform.TotalHeightAngle.
+	Rows int
+
+	// Tilt is passed on to transform.Transform
+	Tilt float64
+
+	// AutoFrame chooses Tilt, and Rows if it is 0, so that the visible terrain fits in the view
+	AutoFrame bool
+
+	// MaxRows limits the Rows chosen by AutoFrame, or 0 for no limit other than the number of columns
+	MaxRows int
+
+	// Panorama renders the full circle from Start, whatever the Width, with a compass scale along the bottom of the
+	// image. Column 0 follows the last column.
+	Panorama bool
+
+	// Projection is passed on to transform.Transform
+	Projection transform.Projection
+
 	Easting    float64
 	Northing   float64
 	Elevations dataset.ElevationMap
+
+	// Interpolation is passed on to transform.Transform. The default is fast, but coarse on near slopes.
+	Interpolation dataset.Interpolation
 }
 
 // fadeFromDistance is a distance from where we add white to the color to make it fade
 const fadeFromDistance = 70000.0
 const subPixels = 3
 
+// rows returns Rows, or the default, which is at least one row
+func (r Renderer) rows() int {
+	if r.Rows == 0 {
+		return int(math.Max(1, transform.TotalHeightAngle*float64(r.Columns)/r.Width))
+	}
+	return r.Rows
+}
+
+// HeightAngle returns the vertical angle of the view in radians
+func (r Renderer) HeightAngle() float64 {
+	if r.Rows == 0 {
+		return transform.TotalHeightAngle
+	}
+	return float64(r.Rows) * r.Width / float64(r.Columns)
+}
+
+// transform returns the Transform for the view. The viewpoint is moved to the nearest point in the grid of the
+// finest tile under it.
 func (r Renderer) transform() transform.Transform {
+	spacing := r.Elevations.SpacingAt(r.Easting, r.Northing)
 	return transform.Transform{
-		Easting:     math.Round(r.Easting/10) * 10,
-		Northing:    math.Round(r.Northing/10) * 10,
-		ElevMap:     r.Elevations,
-		GeoPixelLen: int(transform.TotalHeightAngle*float64(r.Columns)/r.Width) * subPixels,
+		Easting:       math.Round(r.Easting/spacing) * spacing,
+		Northing:      math.Round(r.Northing/spacing) * spacing,
+		ElevMap:       r.Elevations,
+		GeoPixelLen:   r.rows() * subPixels,
+		HeightAngle:   r.HeightAngle(),
+		Tilt:          r.Tilt,
+		Interpolation: r.Interpolation,
+		Projection:    r.Projection,
 	}
 }
 
+// view returns the Renderer with the Panorama and AutoFrame settings applied
+func (r Renderer) view() Renderer {
+	if r.Panorama {
+		r.Width = 2 * math.Pi
+	}
+	return r.frame()
+}
+
 // PixelToUTM convert pixel position to UTM easting+northing
 func (r Renderer) PixelToUTM(posX int, posY int) (easting float64, northing float64, err error) {
+	r = r.view()
 	trans := r.transform()
 
 	rad := r.Start + (float64(posX) * r.Width / float64(r.Columns))
-	geoPixels := trans.TraceDirection(rad, make([]transform.GeoPixel, 0, 5000))
+	geoPixels := trans.TraceDirection(rad, make([]transform.GeoPixel, 0, trans.GeoPixelLen))
 
 	idx := trans.GeoPixelLen - posY*subPixels
-	if idx >= len(geoPixels) {
+	if idx < 0 || idx >= len(geoPixels) {
 		return math.NaN(), math.NaN(), fmt.Errorf("invalid position")
 	}
 
@@ -48,37 +107,80 @@ func (r Renderer) PixelToUTM(posX int, posY int) (easting float64, northing floa
 	return
 }
 
-// CreateImage builds the image from the elevation data
+// CreateImage builds the image from the elevation data. The columns are traced in parallel, by up to GOMAXPROCS
+// goroutines.
 func (r Renderer) CreateImage() *image.RGBA {
+	return r.createImage(runtime.GOMAXPROCS(0))
+}
+
+// createImage builds the image with the given number of goroutines tracing columns. The image is the same for any
+// number of goroutines, since each column is only written by the goroutine that traced it.
+func (r Renderer) createImage(workers int) *image.RGBA {
+	r = r.view()
 	trans := r.transform()
 
+	// TraceDirection initializes the Transform on the first call, which must not happen in several goroutines
+	trans.Init()
+
+	height := trans.GeoPixelLen / subPixels
+	if r.Panorama {
+		height += compassRows
+	}
 	img := image.NewRGBA(image.Rectangle{
 		Min: image.Point{X: 0, Y: 0},
-		Max: image.Point{X: r.Columns, Y: trans.GeoPixelLen / subPixels},
+		Max: image.Point{X: r.Columns, Y: height},
 	})
 
-	var pixels [5000]transform.GeoPixel
+	columns := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pixels := make([]transform.GeoPixel, 0, trans.GeoPixelLen)
+			for i := range columns {
+				r.drawColumn(img, &trans, i, pixels)
+			}
+		}()
+	}
 	for i := 0; i < r.Columns; i++ {
-		rad := r.Start + (float64(r.Columns-i) * r.Width / float64(r.Columns))
+		columns <- i
+	}
+	close(columns)
+	wg.Wait()
 
-		geoPixels := trans.TraceDirection(rad, pixels[:0])
+	if r.Panorama {
+		r.drawCompass(img, trans.GeoPixelLen/subPixels)
+	}
+	return img
+}
 
-		l := len(geoPixels)
-		if l > trans.GeoPixelLen {
-			l = trans.GeoPixelLen
-		}
-		for j := 0; j < l; j += subPixels {
-			c := gradient1.getRGB(geoPixels[j])
-			alpha := 255 / subPixels
-			for k := 1; k < subPixels; k++ {
-				if j+k < l {
-					c = c.add(gradient1.getRGB(geoPixels[j+k]))
-					alpha += 255 / subPixels
-				}
+// drawColumn traces the direction of column i from the right and draws it in img. The pixels are used as buffer for
+// the geo pixels.
+func (r Renderer) drawColumn(img *image.RGBA, trans *transform.Transform, i int, pixels []transform.GeoPixel) {
+	rad := r.Start + (float64(r.Columns-i) * r.Width / float64(r.Columns))
+
+	// The first direction is the last column of a panorama, which wraps around to column 0
+	x := r.Columns - i
+	if r.Panorama {
+		x %= r.Columns
+	}
+
+	geoPixels := trans.TraceDirection(rad, pixels[:0])
+
+	l := len(geoPixels)
+	if l > trans.GeoPixelLen {
+		l = trans.GeoPixelLen
+	}
+	for j := 0; j < l; j += subPixels {
+		c := pixelRGB(geoPixels[j])
+		alpha := 255 / subPixels
+		for k := 1; k < subPixels; k++ {
+			if j+k < l {
+				c = c.add(pixelRGB(geoPixels[j+k]))
+				alpha += 255 / subPixels
 			}
-			img.Set(r.Columns-i, (trans.GeoPixelLen-j)/subPixels, c.normalize().getColor(uint8(alpha)))
 		}
-
+		img.Set(x, (trans.GeoPixelLen-j)/subPixels, c.normalize().getColor(uint8(alpha)))
 	}
-	return img
 }
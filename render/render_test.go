@@ -0,0 +1,146 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/larschri/blaneblikk/dataset"
+	"github.com/larschri/blaneblikk/internal/terraintest"
+	"github.com/larschri/blaneblikk/transform"
+)
+
+func TestMain(m *testing.M) {
+	terraintest.Main(m)
+}
+
+func TestAutoFrame(t *testing.T) {
+	elevationMap := terraintest.Load(t)
+	for _, start := range []float64{0, 1, 2.5, 4} {
+		r := Renderer{
+			Start:      start,
+			Width:      math.Pi * 2 / 16,
+			Columns:    200,
+			Easting:    terraintest.Easting,
+			Northing:   terraintest.Northing,
+			Elevations: elevationMap,
+			AutoFrame:  true,
+		}
+
+		framed := r.frame()
+		if framed.AutoFrame || framed.Rows < 20 || framed.Rows > 200 {
+			t.Fatalf("view at %v is framed with %d rows", start, framed.Rows)
+		}
+
+		// The skyline is in the view, near the top
+		trans := framed.transform()
+		skyline := 0
+		for i := 0; i < r.Columns; i++ {
+			rad := r.Start + float64(i)*r.Width/float64(r.Columns)
+			skyline = int(math.Max(float64(skyline), float64(len(trans.TraceDirection(rad, nil)))))
+		}
+		if skyline >= trans.GeoPixelLen-1 || skyline < trans.GeoPixelLen*3/4 {
+			t.Errorf("view at %v has the skyline at %d of %d geo pixels", start, skyline, trans.GeoPixelLen)
+		}
+
+		// The rows are limited by MaxRows
+		r.MaxRows = framed.Rows / 2
+		if limited := r.frame(); limited.Rows != r.MaxRows {
+			t.Errorf("view at %v is framed with %d rows, want %d", start, limited.Rows, r.MaxRows)
+		}
+		r.MaxRows = 0
+
+		// Only the tilt is chosen when the rows are given
+		r.Rows = 300
+		if framed = r.frame(); framed.Rows != 300 || framed.Tilt == 0 {
+			t.Errorf("view at %v with rows is framed with %d rows and tilt %v", start, framed.Rows, framed.Tilt)
+		}
+	}
+}
+
+func TestAutoFrameWithoutTerrain(t *testing.T) {
+	r := Renderer{
+		Start:      0,
+		Width:      math.Pi * 2 / 64,
+		Columns:    100,
+		Easting:    terraintest.Easting + 10*terraintest.TileSize,
+		Northing:   terraintest.Northing,
+		Elevations: terraintest.Load(t),
+		AutoFrame:  true,
+	}
+	if framed := r.frame(); framed.Rows != 0 || framed.Tilt != 0 {
+		t.Errorf("view without terrain is framed with %d rows and tilt %v", framed.Rows, framed.Tilt)
+	}
+}
+
+func TestPanorama(t *testing.T) {
+	r := Renderer{
+		Start:      0.3,
+		Columns:    360,
+		Rows:       60,
+		Easting:    terraintest.Easting,
+		Northing:   terraintest.Northing,
+		Elevations: terraintest.Load(t),
+		Panorama:   true,
+	}
+	img := r.CreateImage()
+	if size := img.Bounds().Size(); size.X != r.Columns || size.Y != r.Rows+compassRows {
+		t.Fatalf("panorama is %v, want %dx%d", size, r.Columns, r.Rows+compassRows)
+	}
+
+	// Starting one column later shifts the whole panorama one column to the left, also across the edges
+	r.Start += 2 * math.Pi / float64(r.Columns)
+	shifted := r.CreateImage()
+	for x := 0; x < r.Columns; x++ {
+		for y := 0; y < r.Rows; y++ {
+			if got, want := shifted.RGBAAt(x, y), img.RGBAAt((x+1)%r.Columns, y); got != want {
+				t.Fatalf("pixel %d,%d is %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	// The compass has a long tick to the north
+	north := r.compassColumn(0)
+	if shifted.RGBAAt(north, r.Rows+5) != compassForeground || shifted.RGBAAt(north, r.Rows+compassRows-1) != compassBackground {
+		t.Errorf("no tick to the north at column %d", north)
+	}
+}
+
+func TestParallelImage(t *testing.T) {
+	elevationMap := terraintest.Load(t)
+	for _, r := range []Renderer{
+		{Start: 1, Width: math.Pi * 2 / 16, Columns: 300, AutoFrame: true},
+		{Start: 4, Width: math.Pi * 2 / 64, Columns: 200, Interpolation: dataset.Bicubic},
+		{Start: 0.3, Columns: 360, Rows: 60, Panorama: true, Projection: transform.Cylindrical},
+	} {
+		r.Easting, r.Northing, r.Elevations = terraintest.Easting, terraintest.Northing, elevationMap
+		want := r.createImage(1)
+		for _, workers := range []int{2, 7} {
+			got := r.createImage(workers)
+			if got.Rect != want.Rect || !bytes.Equal(got.Pix, want.Pix) {
+				t.Errorf("view at %v with %d workers differs from the sequential view", r.Start, workers)
+			}
+		}
+	}
+}
+
+func BenchmarkCreateImage(b *testing.B) {
+	r := Renderer{
+		Start:      1,
+		Width:      math.Pi * 2 / 16,
+		Columns:    800,
+		Easting:    terraintest.Easting,
+		Northing:   terraintest.Northing,
+		Elevations: terraintest.Load(b),
+	}
+	for _, workers := range []int{1, runtime.GOMAXPROCS(0)} {
+		workers := workers
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				r.createImage(workers)
+			}
+		})
+	}
+}
@@ -2,61 +2,256 @@ package server
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image/png"
 	"log"
 	"math"
 	"net"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/larschri/blaneblikk/dataset"
 	"github.com/larschri/blaneblikk/render"
+	"github.com/larschri/blaneblikk/transform"
 )
 
 // Server is the http server
 type Server struct {
+	// ElevationMap is the current ElevationMap. It is replaced by Reload, and must not be changed after Serve is
+	// called.
 	ElevationMap dataset.ElevationMap
 	Listener     net.Listener
+
+	// LoadElevationMap loads a new ElevationMap for Reload. Reload is not supported if it is nil.
+	LoadElevationMap func() (dataset.ElevationMap, error)
+
+	// AdminReload serves POST /admin/reload, which calls Reload. It is unauthenticated, and reloading maps all the
+	// tiles again, so it should only be enabled when the clients are trusted.
+	AdminReload bool
+
+	// mutex protects ElevationMap and stopped
+	mutex   sync.Mutex
+	stopped bool
+
+	// reloadMutex makes sure there is only one reload at a time
+	reloadMutex sync.Mutex
 }
 
-func (srv *Server) requestToRenderer(req *http.Request) (render.Renderer, error) {
-	lat0, err := strconv.ParseFloat(req.URL.Query().Get("lat0"), 64)
+// Reload loads a new ElevationMap and replaces the current one. Running requests keep using the old ElevationMap,
+// which is closed when they are done. The current ElevationMap is kept if loading fails.
+func (srv *Server) Reload() error {
+	if srv.LoadElevationMap == nil {
+		return errors.New("reload is not supported")
+	}
+
+	srv.reloadMutex.Lock()
+	defer srv.reloadMutex.Unlock()
+
+	elevationMap, err := srv.LoadElevationMap()
 	if err != nil {
-		return render.Renderer{}, fmt.Errorf("failed to parse lat0")
+		return err
+	}
+
+	srv.mutex.Lock()
+	if srv.stopped {
+		srv.mutex.Unlock()
+		elevationMap.Close()
+		return errors.New("server is stopped")
 	}
+	old := srv.ElevationMap
+	srv.ElevationMap = elevationMap
+	srv.mutex.Unlock()
+
+	log.Print("reloaded elevation map")
+	return old.Close()
+}
 
-	lng0, err := strconv.ParseFloat(req.URL.Query().Get("lng0"), 64)
+// acquireElevationMap acquires the current ElevationMap for a request, which must be followed by Release.
+// It writes an error response and returns false if the server is stopped.
+func (srv *Server) acquireElevationMap(w http.ResponseWriter) (dataset.ElevationMap, bool) {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+
+	elevationMap, err := srv.ElevationMap.Acquire()
 	if err != nil {
-		return render.Renderer{}, fmt.Errorf("failed to parse lng0")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return elevationMap, false
+	}
+	return elevationMap, true
+}
+
+// interpolations are the values of the interpolation query parameter
+var interpolations = map[string]dataset.Interpolation{
+	"":         0,
+	"bilinear": dataset.Bilinear,
+	"bicubic":  dataset.Bicubic,
+}
+
+// projections are the values of the projection query parameter
+var projections = map[string]transform.Projection{
+	"":                transform.Equirectangular,
+	"equirectangular": transform.Equirectangular,
+	"cylindrical":     transform.Cylindrical,
+}
+
+// Limits for the size of the views
+const (
+	defaultFov     = 360.0 / 64
+	minFov         = 0.1
+	maxFov         = 360.0
+	defaultColumns = 800
+	maxColumns     = 4000
+	maxRows        = 2000
+
+	// panoramaColumns is the default width of panoramas, which is 10 columns per degree
+	panoramaColumns = 3600
+
+	// maxHeightAngle is the maximum vertical angle of the view in degrees
+	maxHeightAngle = 90.0
+
+	// maxPitch is the maximum angle of the top and bottom of the view above or below the horizontal, in degrees
+	maxPitch = 89.0
+)
+
+// viewSize returns the horizontal angle in degrees, and the width and height in pixels from the fov, width and height
+// query parameters. The rows are 0 if height is not given, which gives the default vertical angle if it is not too
+// many rows. Panoramas are 360 degrees.
+func viewSize(req *http.Request, panorama bool) (fov float64, columns int, rows int, err error) {
+	fov, columns = defaultFov, defaultColumns
+	if panorama {
+		fov, columns = maxFov, panoramaColumns
+	}
+	if s := req.URL.Query().Get("fov"); s != "" {
+		if fov, err = strconv.ParseFloat(s, 64); err != nil || !(fov >= minFov && fov <= maxFov) {
+			return 0, 0, 0, fmt.Errorf("fov must be between %v and %v degrees", minFov, maxFov)
+		}
+		if panorama && fov != maxFov {
+			return 0, 0, 0, fmt.Errorf("fov must be %v degrees for panoramas", maxFov)
+		}
+	}
+
+	if s := req.URL.Query().Get("width"); s != "" {
+		if columns, err = strconv.Atoi(s); err != nil || columns < 1 || columns > maxColumns {
+			return 0, 0, 0, fmt.Errorf("width must be between 1 and %d pixels", maxColumns)
+		}
 	}
 
-	lat1, err := strconv.ParseFloat(req.URL.Query().Get("lat1"), 64)
+	if s := req.URL.Query().Get("height"); s != "" {
+		if rows, err = strconv.Atoi(s); err != nil || rows < 1 || rows > maxRows {
+			return 0, 0, 0, fmt.Errorf("height must be between 1 and %d pixels", maxRows)
+		}
+		if fov*float64(rows)/float64(columns) > maxHeightAngle {
+			return 0, 0, 0, fmt.Errorf("the vertical angle must be at most %v degrees", maxHeightAngle)
+		}
+	} else if transform.TotalHeightAngle*180/math.Pi*float64(columns)/fov > maxRows {
+		rows = maxRows
+	}
+	return fov, columns, rows, nil
+}
+
+// Limits for latitudes and longitudes in degrees
+const (
+	maxLat = 90.0
+	maxLng = 180.0
+)
+
+// coordinateParam parses the given query parameter as a latitude or longitude, which must be within ±limit degrees
+func coordinateParam(req *http.Request, name string, limit float64) (float64, error) {
+	value, err := strconv.ParseFloat(req.URL.Query().Get(name), 64)
 	if err != nil {
-		return render.Renderer{}, fmt.Errorf("failed to parse lat1")
+		return 0, fmt.Errorf("failed to parse %s", name)
+	}
+	if !(math.Abs(value) <= limit) {
+		return 0, fmt.Errorf("%s must be between %v and %v degrees", name, -limit, limit)
 	}
+	return value, nil
+}
 
-	lng1, err := strconv.ParseFloat(req.URL.Query().Get("lng1"), 64)
+func requestToRenderer(req *http.Request, elevationMap dataset.ElevationMap) (render.Renderer, error) {
+	lat0, err := coordinateParam(req, "lat0", maxLat)
 	if err != nil {
-		return render.Renderer{}, fmt.Errorf("failed to parse lng1")
+		return render.Renderer{}, err
 	}
 
-	easting, northing := dataset.DTM10UTM32Dataset.LatLngToUTM(lat0, lng0)
-	easting1, northing1 := dataset.DTM10UTM32Dataset.LatLngToUTM(lat1, lng1)
+	lng0, err := coordinateParam(req, "lng0", maxLng)
+	if err != nil {
+		return render.Renderer{}, err
+	}
+
+	interpolation, ok := interpolations[req.URL.Query().Get("interpolation")]
+	if !ok {
+		return render.Renderer{}, fmt.Errorf("unknown interpolation")
+	}
+
+	projection, ok := projections[req.URL.Query().Get("projection")]
+	if !ok {
+		return render.Renderer{}, fmt.Errorf("unknown projection")
+	}
+
+	// The grid of the UTM zone for the viewpoint is used for the whole view
+	zone := elevationMap.ZoneFor(lat0, lng0)
+	utm := dataset.NewUTM(zone)
+	easting, northing := utm.LatLngToUTM(lat0, lng0)
+
+	// The view is a panorama from north if there is no direction
+	panorama := req.URL.Query().Get("lat1") == "" && req.URL.Query().Get("lng1") == ""
+	fov, columns, rows, err := viewSize(req, panorama)
+	if err != nil {
+		return render.Renderer{}, err
+	}
+	width := fov * math.Pi / 180
 
-	width := math.Pi * 2 / 64
-	angle := -math.Atan2(easting-easting1, northing1-northing)
+	start := 0.0
+	if !panorama {
+		lat1, err := coordinateParam(req, "lat1", maxLat)
+		if err != nil {
+			return render.Renderer{}, err
+		}
 
-	return render.Renderer{
-		Start:      angle - width/2,
-		Width:      width,
-		Columns:    800,
-		Easting:    easting,
-		Northing:   northing,
-		Elevations: srv.ElevationMap,
-	}, nil
+		lng1, err := coordinateParam(req, "lng1", maxLng)
+		if err != nil {
+			return render.Renderer{}, err
+		}
+
+		easting1, northing1 := utm.LatLngToUTM(lat1, lng1)
+		angle := -math.Atan2(easting-easting1, northing1-northing)
+		start = angle - width/2
+	}
+
+	renderer := render.Renderer{
+		Start:         start,
+		Width:         width,
+		Columns:       columns,
+		Rows:          rows,
+		Easting:       easting,
+		Northing:      northing,
+		Elevations:    elevationMap.InZone(zone),
+		Interpolation: interpolation,
+		Panorama:      panorama,
+		Projection:    projection,
+		MaxRows:       maxRows,
+	}
+
+	// The view is framed to fit the terrain, unless the pitch is given
+	s := req.URL.Query().Get("pitch")
+	if s == "" {
+		renderer.AutoFrame = true
+		return renderer, nil
+	}
+
+	pitch, err := strconv.ParseFloat(s, 64)
+	heightAngle := renderer.HeightAngle() * 180 / math.Pi
+	if err != nil || !(math.Abs(pitch)+heightAngle/2 < maxPitch) {
+		return render.Renderer{}, fmt.Errorf("pitch must be between %v and %v degrees", -maxPitch+heightAngle/2, maxPitch-heightAngle/2)
+	}
+	renderer.Tilt = pitch*math.Pi/180 - transform.DefaultPitch(renderer.HeightAngle())
+	return renderer, nil
 }
 
 func writeJSONResponse(w http.ResponseWriter, result interface{}) {
@@ -74,7 +269,13 @@ func writeJSONResponse(w http.ResponseWriter, result interface{}) {
 }
 
 func (srv *Server) handlePixelToLatLng(w http.ResponseWriter, req *http.Request) {
-	renderer, err := srv.requestToRenderer(req)
+	elevationMap, ok := srv.acquireElevationMap(w)
+	if !ok {
+		return
+	}
+	defer elevationMap.Release()
+
+	renderer, err := requestToRenderer(req, elevationMap)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -98,7 +299,8 @@ func (srv *Server) handlePixelToLatLng(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	lat, lng := dataset.DTM10UTM32Dataset.UTMToLatLng(easting, northing)
+	utm := dataset.NewUTM(renderer.Elevations.Zone())
+	lat, lng := utm.UTMToLatLng(easting, northing)
 	writeJSONResponse(w, map[string]interface{}{
 		"lat": lat,
 		"lng": lng,
@@ -106,9 +308,15 @@ func (srv *Server) handlePixelToLatLng(w http.ResponseWriter, req *http.Request)
 }
 
 func (srv *Server) handleImageRequest(w http.ResponseWriter, req *http.Request) {
+	elevationMap, ok := srv.acquireElevationMap(w)
+	if !ok {
+		return
+	}
+	defer elevationMap.Release()
+
 	w.Header().Add("Content-Type", "image/png")
 
-	renderer, err := srv.requestToRenderer(req)
+	renderer, err := requestToRenderer(req, elevationMap)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -120,6 +328,151 @@ func (srv *Server) handleImageRequest(w http.ResponseWriter, req *http.Request)
 	}
 }
 
+func (srv *Server) handleElevation(w http.ResponseWriter, req *http.Request) {
+	lat, err := coordinateParam(req, "lat", maxLat)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lng, err := coordinateParam(req, "lng", maxLng)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	elevationMap, ok := srv.acquireElevationMap(w)
+	if !ok {
+		return
+	}
+	defer elevationMap.Release()
+
+	p, err := elevationMap.ElevationAtLatLng(lat, lng)
+	var noData *dataset.NoDataError
+	if errors.As(err, &noData) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, map[string]interface{}{
+		"lat":       lat,
+		"lng":       lng,
+		"elevation": p.Elevation,
+		"zone":      p.Zone,
+		"easting":   p.Easting,
+		"northing":  p.Northing,
+		"spacing":   p.Tile.Spacing,
+		"file":      filepath.Base(p.FName),
+	})
+}
+
+// maxProfilePoints limits the number of points in a profile
+const maxProfilePoints = 10_000
+
+// coordinateParams parses the given query parameters as pairs of latitude and longitude
+func coordinateParams(req *http.Request, names ...string) ([]float64, error) {
+	values := make([]float64, len(names))
+	for i, name := range names {
+		limit := maxLat
+		if i%2 == 1 {
+			limit = maxLng
+		}
+		value, err := coordinateParam(req, name, limit)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// nullable returns nil for NaN, which is not supported by JSON
+func nullable(f float64) interface{} {
+	if math.IsNaN(f) {
+		return nil
+	}
+	return f
+}
+
+// csvFloat formats f for CSV, where NaN is empty
+func csvFloat(f float64) string {
+	if math.IsNaN(f) {
+		return ""
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func (srv *Server) handleProfile(w http.ResponseWriter, req *http.Request) {
+	params, err := coordinateParams(req, "lat0", "lng0", "lat1", "lng1")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	spacing := 100.0
+	if s := req.URL.Query().Get("spacing"); s != "" {
+		if spacing, err = strconv.ParseFloat(s, 64); err != nil || !(spacing > 0) || math.IsInf(spacing, 1) {
+			http.Error(w, "failed to parse spacing", http.StatusBadRequest)
+			return
+		}
+	}
+
+	format := req.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, "unknown format", http.StatusBadRequest)
+		return
+	}
+
+	if dataset.GreatCircleDistance(params[0], params[1], params[2], params[3])/spacing > maxProfilePoints {
+		http.Error(w, "too many points, increase spacing", http.StatusBadRequest)
+		return
+	}
+
+	elevationMap, ok := srv.acquireElevationMap(w)
+	if !ok {
+		return
+	}
+	defer elevationMap.Release()
+
+	points, err := elevationMap.Profile(params[0], params[1], params[2], params[3], spacing)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Add("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"distance", "lat", "lng", "elevation", "curvedElevation"})
+		for _, p := range points {
+			cw.Write([]string{csvFloat(p.Distance), csvFloat(p.Lat), csvFloat(p.Lng), csvFloat(p.Elevation), csvFloat(p.CurvedElevation)})
+		}
+		cw.Flush()
+		if err = cw.Error(); err != nil {
+			log.Printf("failed to write HTTP response: %v", err)
+		}
+		return
+	}
+
+	result := make([]map[string]interface{}, len(points))
+	for i, p := range points {
+		result[i] = map[string]interface{}{
+			"distance":        p.Distance,
+			"lat":             p.Lat,
+			"lng":             p.Lng,
+			"elevation":       nullable(p.Elevation),
+			"curvedElevation": nullable(p.CurvedElevation),
+		}
+	}
+	writeJSONResponse(w, map[string]interface{}{
+		"points": result,
+	})
+}
+
 // shutdownWhenDone invokes http.Server.Shutdown when the given context is cancelled.
 // This function will block until context cancellation.
 func shutdownWhenDone(ctx context.Context, server *http.Server) {
@@ -133,15 +486,54 @@ func shutdownWhenDone(ctx context.Context, server *http.Server) {
 	server.Shutdown(c)
 }
 
-// Serve starts a http server and blocks until the given context is cancelled
-func (srv *Server) Serve(ctx context.Context) error {
+func (srv *Server) handleReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "reload requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := srv.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, map[string]interface{}{
+		"reloaded": true,
+	})
+}
+
+// stop closes the current ElevationMap, after the running requests are done
+func (srv *Server) stop() {
+	srv.mutex.Lock()
+	srv.stopped = true
+	elevationMap := srv.ElevationMap
+	srv.mutex.Unlock()
+
+	if err := elevationMap.Close(); err != nil {
+		log.Printf("failed to close elevation map: %v", err)
+	}
+}
+
+// handler returns the handler for the endpoints of the server
+func (srv *Server) handler() http.Handler {
 	m := http.NewServeMux()
 	m.HandleFunc("/bb/pixelLatLng", srv.handlePixelToLatLng)
 	m.HandleFunc("/bb", srv.handleImageRequest)
+	m.HandleFunc("/elevation", srv.handleElevation)
+	m.HandleFunc("/profile", srv.handleProfile)
+	if srv.AdminReload {
+		m.HandleFunc("/admin/reload", srv.handleReload)
+	}
 	m.Handle("/", http.FileServer(http.Dir("server/static")))
+	return m
+}
+
+// Serve starts a http server and blocks until the given context is cancelled.
+// The ElevationMap is closed when the server stops, after the running requests are done.
+func (srv *Server) Serve(ctx context.Context) error {
+	defer srv.stop()
 
 	server := http.Server{
-		Handler: m,
+		Handler: srv.handler(),
 	}
 
 	go shutdownWhenDone(ctx, &server)
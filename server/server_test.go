@@ -0,0 +1,366 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/larschri/blaneblikk/dataset"
+	"github.com/larschri/blaneblikk/transform"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	load := func() (dataset.ElevationMap, error) {
+		elevationMap, _, err := dataset.LoadFiles(dataset.ReaderByExtension{}, dir, nil, dataset.LoadOptions{})
+		return elevationMap, err
+	}
+
+	first, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := Server{ElevationMap: first, LoadElevationMap: load}
+
+	// A running request keeps the old map
+	old, ok := srv.acquireElevationMap(httptest.NewRecorder())
+	if !ok {
+		t.Fatal("failed to acquire elevation map")
+	}
+
+	reloaded := make(chan error)
+	go func() {
+		reloaded <- srv.Reload()
+	}()
+
+	// New requests get the new map, while the old map is still open
+	for i := 0; ; i++ {
+		current, ok := srv.acquireElevationMap(httptest.NewRecorder())
+		if !ok {
+			t.Fatal("failed to acquire elevation map")
+		}
+		current.Release()
+		if current != old {
+			break
+		}
+		if i == 1000 {
+			t.Fatal("elevation map was not replaced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	select {
+	case err = <-reloaded:
+		t.Fatalf("reload finished before the old map was released: %v", err)
+	default:
+	}
+
+	old.Release()
+	if err = <-reloaded; err != nil {
+		t.Fatal(err)
+	}
+	if _, err = old.Acquire(); err != dataset.ErrClosed {
+		t.Errorf("old map was not closed: %v", err)
+	}
+
+	// The admin endpoint is only served when it is enabled
+	w := httptest.NewRecorder()
+	srv.handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("POST /admin/reload without AdminReload returned %d", w.Code)
+	}
+
+	// Reload is only allowed with POST
+	srv.AdminReload = true
+	w = httptest.NewRecorder()
+	srv.handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/reload", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /admin/reload returned %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	srv.handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("POST /admin/reload returned %d", w.Code)
+	}
+
+	srv.stop()
+	if err = srv.Reload(); err == nil {
+		t.Error("reload after stop succeeded")
+	}
+}
+
+func TestElevationAndProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	elevationMap, _, err := dataset.LoadFiles(dataset.ReaderByExtension{}, dir, nil, dataset.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := Server{ElevationMap: elevationMap}
+	defer srv.stop()
+	m := http.NewServeMux()
+	m.HandleFunc("/elevation", srv.handleElevation)
+	m.HandleFunc("/profile", srv.handleProfile)
+
+	for _, tc := range []struct {
+		url  string
+		code int
+	}{
+		{"/elevation?lat=61.6&lng=8.3", http.StatusNotFound},
+		{"/elevation?lat=61.6", http.StatusBadRequest},
+		{"/elevation?lat=north&lng=8.3", http.StatusBadRequest},
+		{"/profile?lat0=61.6&lng0=8.3&lat1=61.5&lng1=7.9", http.StatusOK},
+		{"/profile?lat0=61.6&lng0=8.3&lat1=61.5&lng1=7.9&format=csv", http.StatusOK},
+		{"/profile?lat0=61.6&lng0=8.3&lat1=61.5&lng1=7.9&format=xml", http.StatusBadRequest},
+		{"/profile?lat0=61.6&lng0=8.3&lat1=61.5&lng1=7.9&spacing=0", http.StatusBadRequest},
+		{"/profile?lat0=61.6&lng0=8.3&lat1=61.5&lng1=7.9&spacing=1", http.StatusBadRequest},
+		{"/profile?lat0=61.6&lng0=8.3&lat1=61.5", http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.url, nil))
+		if w.Code != tc.code {
+			t.Errorf("GET %s returned %d, want %d", tc.url, w.Code, tc.code)
+		}
+	}
+}
+
+func TestProfileFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	elevationMap, _, err := dataset.LoadFiles(dataset.ReaderByExtension{}, dir, nil, dataset.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := Server{ElevationMap: elevationMap}
+	defer srv.stop()
+
+	// The points without elevation data are null in JSON and empty in CSV
+	w := httptest.NewRecorder()
+	srv.handleProfile(w, httptest.NewRequest(http.MethodGet, "/profile?lat0=61&lng0=9&lat1=61.01&lng1=9&spacing=500", nil))
+	var result struct {
+		Points []struct {
+			Distance  float64
+			Elevation *float64
+		}
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Points) != 4 || result.Points[3].Distance < 1100 || result.Points[0].Elevation != nil {
+		t.Errorf("unexpected profile %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	srv.handleProfile(w, httptest.NewRequest(http.MethodGet, "/profile?lat0=61&lng0=9&lat1=61.01&lng1=9&spacing=500&format=csv", nil))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 5 || lines[0] != "distance,lat,lng,elevation,curvedElevation" || lines[1] != "0,61,9,," {
+		t.Errorf("unexpected CSV profile %q", lines)
+	}
+
+	// Invalid positions, spacings and antipodal end points are bad requests
+	for _, url := range []string{
+		"/profile?lat0=NaN&lng0=0&lat1=61&lng1=9",
+		"/profile?lat0=61&lng0=9&lat1=91&lng1=9",
+		"/profile?lat0=61&lng0=9&lat1=61&lng1=-Inf",
+		"/profile?lat0=61&lng0=9&lat1=-61&lng1=-171&spacing=1e7",
+		"/profile?lat0=61&lng0=9&lat1=61.01&lng1=9&spacing=Inf",
+		"/elevation?lat=NaN&lng=9",
+		"/elevation?lat=61&lng=181",
+	} {
+		w = httptest.NewRecorder()
+		srv.handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s returned %d", url, w.Code)
+		}
+	}
+}
+
+func TestNarrowViews(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	elevationMap, _, err := dataset.LoadFiles(dataset.ReaderByExtension{}, dir, nil, dataset.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := Server{ElevationMap: elevationMap}
+	defer srv.stop()
+
+	// The default height of views that are wide compared to the width in pixels is less than a pixel, which is
+	// rounded up to one pixel. There is no terrain, so there is no position for the pixel.
+	for _, tc := range []struct {
+		url  string
+		code int
+	}{
+		{"/bb?lat0=61&lng0=8&lat1=61.1&lng1=8&fov=60&width=10", http.StatusOK},
+		{"/bb/pixelLatLng?lat0=61&lng0=8&lat1=61.1&lng1=8&fov=60&width=10&offsetX=5&offsetY=0", http.StatusBadRequest},
+		{"/bb?lat0=-90&lng0=8&width=50", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		srv.handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.url, nil))
+		if w.Code != tc.code {
+			t.Errorf("GET %s returned %d, want %d", tc.url, w.Code, tc.code)
+		}
+	}
+}
+
+func TestViewSize(t *testing.T) {
+	for _, tc := range []struct {
+		query         string
+		fov           float64
+		columns, rows int
+		fail          bool
+	}{
+		{"", 360.0 / 64, 800, 0, false},
+		{"fov=60&width=1200&height=600", 60, 1200, 600, false},
+		{"fov=360&width=3600&height=900", 360, 3600, 900, false},
+		{"fov=0.5&width=4000", 0.5, 4000, maxRows, false},
+		{"fov=0", 0, 0, 0, true},
+		{"fov=361", 0, 0, 0, true},
+		{"fov=wide", 0, 0, 0, true},
+		{"width=0", 0, 0, 0, true},
+		{"width=4001", 0, 0, 0, true},
+		{"height=2001", 0, 0, 0, true},
+		{"fov=360&width=800&height=300", 0, 0, 0, true},
+	} {
+		fov, columns, rows, err := viewSize(httptest.NewRequest(http.MethodGet, "/bb?"+tc.query, nil), false)
+		if (err != nil) != tc.fail || fov != tc.fov || columns != tc.columns || rows != tc.rows {
+			t.Errorf("viewSize(%q) = %v, %d, %d, %v", tc.query, fov, columns, rows, err)
+		}
+	}
+}
+
+func TestPitch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	elevationMap, _, err := dataset.LoadFiles(dataset.ReaderByExtension{}, dir, nil, dataset.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elevationMap.Close()
+
+	const view = "/bb?lat0=61.6&lng0=8.3&lat1=61.5&lng1=7.9&fov=60&width=600&height=300"
+	for _, tc := range []struct {
+		query string
+		pitch float64
+		fail  bool
+	}{
+		{"&pitch=0", 0, false},
+		{"&pitch=-10.5", -10.5, false},
+		{"&pitch=73.9", 73.9, false},
+		{"&pitch=74", 0, true},
+		{"&pitch=up", 0, true},
+	} {
+		renderer, err := requestToRenderer(httptest.NewRequest(http.MethodGet, view+tc.query, nil), elevationMap)
+		if (err != nil) != tc.fail {
+			t.Errorf("pitch %q gives error %v", tc.query, err)
+			continue
+		}
+
+		if !tc.fail && renderer.MaxRows != maxRows {
+			t.Errorf("pitch %q gives at most %d rows, want %d", tc.query, renderer.MaxRows, maxRows)
+		}
+
+		// The pitch is the angle of the middle of the view
+		trans := transform.Transform{HeightAngle: renderer.HeightAngle(), Tilt: renderer.Tilt}
+		if pitch := (trans.BottomAngle() + renderer.HeightAngle()/2) * 180 / math.Pi; !tc.fail && math.Abs(pitch-tc.pitch) > 1e-9 {
+			t.Errorf("pitch %q gives %v", tc.query, pitch)
+		}
+	}
+}
+
+func TestPanoramaRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	elevationMap, _, err := dataset.LoadFiles(dataset.ReaderByExtension{}, dir, nil, dataset.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elevationMap.Close()
+
+	for _, tc := range []struct {
+		query      string
+		columns    int
+		projection transform.Projection
+		fail       bool
+	}{
+		{"", panoramaColumns, transform.Equirectangular, false},
+		{"&fov=360&width=7200", 0, 0, true},
+		{"&width=4000&height=300&projection=cylindrical", 4000, transform.Cylindrical, false},
+		{"&fov=90", 0, 0, true},
+		{"&projection=fisheye", 0, 0, true},
+		{"&lat1=61.5", 0, 0, true},
+	} {
+		renderer, err := requestToRenderer(httptest.NewRequest(http.MethodGet, "/bb?lat0=61.6&lng0=8.3"+tc.query, nil), elevationMap)
+		if (err != nil) != tc.fail {
+			t.Errorf("%q gives error %v", tc.query, err)
+			continue
+		}
+		if !tc.fail && (!renderer.Panorama || renderer.Columns != tc.columns || renderer.Projection != tc.projection) {
+			t.Errorf("%q gives %+v", tc.query, renderer)
+		}
+	}
+}
+
+func TestCoordinates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	elevationMap, _, err := dataset.LoadFiles(dataset.ReaderByExtension{}, dir, nil, dataset.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elevationMap.Close()
+
+	for _, tc := range []struct {
+		query string
+		fail  bool
+	}{
+		{"lat0=61.6&lng0=8.3&lat1=61.5&lng1=7.9", false},
+		{"lat0=-90&lng0=-180&lat1=90&lng1=180", false},
+		{"lat0=1e300&lng0=1e300", true},
+		{"lat0=NaN&lng0=8.3", true},
+		{"lat0=61.6&lng0=Inf", true},
+		{"lat0=61.6&lng0=180.5", true},
+		{"lat0=61.6&lng0=8.3&lat1=-91&lng1=7.9", true},
+		{"lat0=61.6&lng0=8.3&lat1=61.5&lng1=NaN", true},
+	} {
+		if _, err := requestToRenderer(httptest.NewRequest(http.MethodGet, "/bb?"+tc.query, nil), elevationMap); (err != nil) != tc.fail {
+			t.Errorf("%q gives error %v", tc.query, err)
+		}
+	}
+}
@@ -19,9 +19,64 @@ function onMapClick(e) {
 		pos0 = marker.getLatLng();
 		pos1 = marker2.getLatLng();
 		document.querySelector("#bbImg").src = `bb?lat0=${pos0.lat}&lng0=${pos0.lng}&lat1=${pos1.lat}&lng1=${pos1.lng}`;
+		showProfile(pos0, pos1);
 	}
 }
 
+// eyeHeight is the height of the viewpoint above the terrain, like in the rendered views
+const eyeHeight = 9;
+
+// showProfile draws the elevation profile from pos0 to pos1, with the line of sight from pos0 to pos1. The terrain
+// is lowered by the earth curvature, so that the line of sight is straight.
+function showProfile(pos0, pos1) {
+	let request = new XMLHttpRequest();
+	request.open("GET", `profile?lat0=${pos0.lat}&lng0=${pos0.lng}&lat1=${pos1.lat}&lng1=${pos1.lng}&spacing=${Math.max(pos0.distanceTo(pos1) / 800, 10)}`);
+	request.responseType = "json";
+	request.send();
+	request.onload = function() {
+		if (request.status != 200) {
+			return;
+		}
+		drawProfile(request.response.points);
+	};
+}
+
+function drawProfile(points) {
+	let canvas = document.querySelector("#profile");
+	let ctx = canvas.getContext("2d");
+	ctx.clearRect(0, 0, canvas.width, canvas.height);
+	if (points.length < 2) {
+		return;
+	}
+
+	// Points without elevation data are at sea level, like in the rendered views
+	let curved = p => p.curvedElevation == null ? -p.distance * Math.atan2(p.distance / 2, 6371000) : p.curvedElevation;
+	let first = points[0], last = points[points.length - 1];
+	let eye = (first.elevation == null ? 0 : first.elevation) + eyeHeight;
+	let target = curved(last);
+
+	let elevations = points.map(curved);
+	let min = Math.min(...elevations), max = Math.max(eye, ...elevations);
+	let x = d => d / last.distance * canvas.width;
+	let y = e => canvas.height - 5 - (e - min) / (max - min || 1) * (canvas.height - 10);
+
+	ctx.beginPath();
+	ctx.moveTo(0, canvas.height);
+	points.forEach((p, i) => ctx.lineTo(x(p.distance), y(elevations[i])));
+	ctx.lineTo(canvas.width, canvas.height);
+	ctx.fillStyle = "#8a7";
+	ctx.fill();
+
+	// The line of sight is red if the terrain in between is above it
+	let visible = points.every((p, i) => i == 0 || i == points.length - 1 ||
+		elevations[i] <= eye + (target - eye) * p.distance / last.distance);
+	ctx.beginPath();
+	ctx.moveTo(x(0), y(eye));
+	ctx.lineTo(x(last.distance), y(target));
+	ctx.strokeStyle = visible ? "#06c" : "#c00";
+	ctx.stroke();
+}
+
 function setPos(latlng) {
 	map.panTo(latlng)
 	if (marker == null) {
@@ -43,6 +98,15 @@ document.querySelector('#reset').addEventListener('click', event => {
 	marker2.remove();
 });
 
+// The panorama is the full circle around the first marker
+document.querySelector('#panorama').addEventListener('click', event => {
+	if (marker == null) {
+		return;
+	}
+	let pos0 = marker.getLatLng();
+	document.querySelector("#bbImg").src = `bb?lat0=${pos0.lat}&lng0=${pos0.lng}`;
+});
+
 document.querySelector('#bbImg').addEventListener('click', event => {
     let url = new URL(event.srcElement.src);
     url.pathname = "bb/pixelLatLng"
@@ -56,5 +120,6 @@ document.querySelector('#bbImg').addEventListener('click', event => {
 		.setLatLng(request.response)
 		.addTo(map);
 	map.panInside(request.response)
+	showProfile(marker.getLatLng(), marker2.getLatLng());
     };
 });
@@ -11,7 +11,7 @@ const (
 	maxBlaneDistance = 200_000.0
 
 	// earthRadius is the radius of the earth in meters. Assuming earth is a perfect sphere.
-	earthRadius = 6_371_000.0
+	earthRadius = dataset.EarthRadius
 
 	// bottomHeightAngle is the angle between the straight horizontal line and the bottom of the image
 	bottomHeightAngle = -0.06
@@ -19,6 +19,10 @@ const (
 	// TotalHeightAngle is the angle between bottomHeightAngle and the top of the image
 	TotalHeightAngle = 0.08
 	totalHeightAngle = 0.08
+
+	// spacingSteps is the number of steps that elevation data with one spacing is used for, when there is data with a
+	// coarser spacing. This is the first 5 km for 1 m data and the first 50 km for 10 m data.
+	spacingSteps = 5000
 )
 
 // earthCurvatureDecline contains "elevation penalty" by distance caused by earth curvature. This is an optimisation
@@ -35,8 +39,23 @@ func init() {
 type GeoPixel struct {
 	Distance float64
 	Incline  float64
+
+	// NoData is set for pixels showing points without elevation data, which are traced as water at sea level
+	NoData bool
 }
 
+// Projection is the vertical projection of the view
+type Projection int
+
+const (
+	// Equirectangular gives the same angle to each geo pixel
+	Equirectangular Projection = iota
+
+	// Cylindrical gives the same difference in the tangent of the angle to each geo pixel, like the projection onto
+	// a cylinder around the viewpoint. Terrain far above or below the horizon is stretched vertically.
+	Cylindrical
+)
+
 // Transform contains attributes to perform a transformation
 type Transform struct {
 	Easting     float64
@@ -44,15 +63,82 @@ type Transform struct {
 	ElevMap     dataset.ElevationMap
 	GeoPixelLen int
 	geoPixelTan []float64
+
+	// HeightAngle is the vertical angle of the view in radians, divided into GeoPixelLen geo pixels. The horizon is
+	// at the same height in the view as with the default, which is TotalHeightAngle.
+	HeightAngle float64
+
+	// Tilt is the angle in radians that the view is tilted upwards. The horizon is a quarter of the height below the
+	// top of the view when Tilt is 0.
+	Tilt float64
+
+	// Projection is how the angles between the bottom and the top of the view are divided into geo pixels
+	Projection Projection
+
+	// Interpolation selects interpolation in both directions, with steps in between the grid lines, which is slower.
+	// By default, the steps are on the grid lines of the viewpoint rounded to the grid, and the elevation is
+	// interpolated sideways between the points on the grid line. See traceInterpolated.
+	Interpolation dataset.Interpolation
+
+	// elevMaps is ElevMap with each of the available spacings, finest first
+	elevMaps []dataset.ElevationMap
+
+	// levels is the downsampled levels of each of the elevMaps, starting with the elevMap itself
+	levels [][]dataset.ElevationMap
+}
+
+// DefaultPitch returns the angle above the horizontal of the middle of a view with the given vertical angle, when it
+// is not tilted
+func DefaultPitch(heightAngle float64) float64 {
+	return heightAngle * (bottomHeightAngle/totalHeightAngle + 0.5)
 }
 
-func (t *Transform) init() {
+// BottomAngle returns the angle above the horizontal of the bottom of the view
+func (t *Transform) BottomAngle() float64 {
+	return bottomHeightAngle*(t.heightAngle()/totalHeightAngle) + t.Tilt
+}
+
+// heightAngle returns HeightAngle, or the default
+func (t *Transform) heightAngle() float64 {
+	if t.HeightAngle == 0 {
+		return totalHeightAngle
+	}
+	return t.HeightAngle
+}
+
+// Init prepares the Transform for TraceDirection, which calls it as well. It must be called before calling
+// TraceDirection from several goroutines, since it is not safe for concurrent use.
+func (t *Transform) Init() {
+	if t.elevMaps == nil {
+		for _, spacing := range t.ElevMap.Spacings() {
+			t.elevMaps = append(t.elevMaps, t.ElevMap.WithSpacing(spacing))
+		}
+		if t.elevMaps == nil {
+			t.elevMaps = []dataset.ElevationMap{t.ElevMap}
+		}
+
+		for _, elevationMap := range t.elevMaps {
+			var levels []dataset.ElevationMap
+			for level := 0; level <= dataset.MaxLevel; level++ {
+				levels = append(levels, elevationMap.Level(level))
+			}
+			t.levels = append(t.levels, levels)
+		}
+	}
+
 	if t.geoPixelTan == nil {
 		t.geoPixelTan = make([]float64, t.GeoPixelLen)
 
-		angleStep := totalHeightAngle / float64(t.GeoPixelLen)
+		heightAngle := t.heightAngle()
+		bottom := t.BottomAngle()
+		angleStep := heightAngle / float64(t.GeoPixelLen)
+		tanStep := (math.Tan(bottom+heightAngle) - math.Tan(bottom)) / float64(t.GeoPixelLen)
 		for i := 0; i < t.GeoPixelLen; i++ {
-			t.geoPixelTan[i] = math.Tan(bottomHeightAngle + float64(i)*angleStep)
+			if t.Projection == Cylindrical {
+				t.geoPixelTan[i] = math.Tan(bottom) + float64(i)*tanStep
+				continue
+			}
+			t.geoPixelTan[i] = math.Tan(bottom + float64(i)*angleStep)
 		}
 
 		// terminate with infinity to prevent writes outside bounds
@@ -84,6 +170,9 @@ func (s floatStepper) step(i dataset.IntStep) float64 {
 type geoPixelBuilder struct {
 	stepLength float64
 
+	// elevation0 is the elevation of the viewpoint, and elevations along the way are relative to it
+	elevation0 float64
+
 	geoPixels     []GeoPixel
 	prevElevation float64
 	geoPixelLen   int
@@ -97,27 +186,66 @@ func sign(i float64) dataset.IntStep {
 	return 1
 }
 
-// elevationLimit calculates the lowest elevation that would be visible when traversing the next ElevationMap.
-// The next ElevationMap can be skipped if the maximum elevation is lower than this.
-func (bld *geoPixelBuilder) elevationLimit(i dataset.IntStep) float64 {
-	dist1 := float64(i) * bld.stepLength
-	elevationLimit1 := earthCurvatureDecline[int(dist1/dataset.Unit)] + bld.geoPixelTan[len(bld.geoPixels)]*dist1
+// elevationLimit calculates the lowest elevation that would be visible in the steps from i1 to i2, relative to
+// elevation0. Blocks of the ElevationMap can be skipped if the maximum elevation is lower than this.
+func (bld *geoPixelBuilder) elevationLimit(i1 dataset.IntStep, i2 dataset.IntStep) float64 {
+	tan := bld.geoPixelTan[len(bld.geoPixels)]
+	limit := func(dist float64) float64 {
+		dist = math.Min(dist, maxBlaneDistance)
+		return earthCurvatureDecline[int(dist/dataset.Unit)] + tan*dist
+	}
+
+	dist1 := float64(i1) * bld.stepLength
+	dist2 := float64(i2) * bld.stepLength
+	elevationLimit := math.Min(limit(dist1), limit(dist2))
 
-	dist2 := float64(i+dataset.ElevationMapletSize) * bld.stepLength
-	elevationLimit2 := earthCurvatureDecline[int(dist2/dataset.Unit)] + bld.geoPixelTan[len(bld.geoPixels)]*dist2
+	// The limit is lowest in between where the earth curvature declines as fast as the pixel inclines
+	if bottom := -tan * earthRadius; dist1 < bottom && bottom < dist2 {
+		elevationLimit = math.Min(elevationLimit, limit(bottom))
+	}
+	return elevationLimit
+}
 
-	return math.Min(elevationLimit1, elevationLimit2)
+// skippableSteps returns the number of steps from step i to the end of the largest block that is too low to be
+// seen, or -1 if the maplet at step i may be seen. front is the position at step i in the forward direction, counted
+// in steps of which there are pointSteps between each point, and position returns the easting/northing at a step.
+func (bld *geoPixelBuilder) skippableSteps(elevationMap dataset.ElevationMap, i dataset.IntStep, front dataset.IntStep,
+	frontStepLen dataset.IntStep, pointSteps dataset.IntStep, position func(i dataset.IntStep) (dataset.IntStep, dataset.IntStep)) dataset.IntStep {
+
+	skip := dataset.IntStep(-1)
+	e, n := position(i)
+	for _, size := range dataset.BlockSizes {
+		// The position sideways moves less than a block, so the block is next to the one at the start
+		steps := remainingSteps(front, frontStepLen, size*pointSteps)
+		e1, n1 := position(i + steps)
+		elevationLimit := bld.elevation0 + bld.elevationLimit(i, i+steps)
+		if elevationMap.BlockMaxElevation(e, n, size) >= elevationLimit ||
+			elevationMap.BlockMaxElevation(e1, n1, size) >= elevationLimit {
+			break
+		}
+		skip = steps
+	}
+	return skip
 }
 
 // weightElevation computes the weighted average of two elevations. This is used to compute the elevation for
-// a point on a straight line between two points with known elevations.
-func weightElevation(elevation1 dataset.Elevation16, elevation2 dataset.Elevation16, elevation1Weight float64) float64 {
+// a point on a straight line between two points with known elevations. Points without elevation data are at sea
+// level, and noData is set if the point is closest to one of those.
+func weightElevation(elevation1 dataset.Elevation16, elevation2 dataset.Elevation16, elevation1Weight float64) (elevation float64, noData bool) {
+	if elevation1 == dataset.NoData {
+		elevation1 = 0
+		noData = elevation1Weight < 0.5
+	}
+	if elevation2 == dataset.NoData {
+		elevation2 = 0
+		noData = noData || elevation1Weight >= 0.5
+	}
 	return (float64(elevation2)*elevation1Weight +
-		float64(elevation1)*(1-elevation1Weight)) * dataset.Elevation16Unit
+		float64(elevation1)*(1-elevation1Weight)) * dataset.Elevation16Unit, noData
 }
 
 // updateState updates the elevation and pixels for each step during the iteration
-func (bld *geoPixelBuilder) updateState(elevation float64, i dataset.IntStep) {
+func (bld *geoPixelBuilder) updateState(elevation float64, noData bool, i dataset.IntStep) {
 	dist := float64(i) * bld.stepLength
 
 	elevationX := elevation - earthCurvatureDecline[int(dist/dataset.Unit)]
@@ -127,6 +255,7 @@ func (bld *geoPixelBuilder) updateState(elevation float64, i dataset.IntStep) {
 		pix := GeoPixel{
 			Distance: dist,
 			Incline:  (elevation - bld.prevElevation) * dataset.Unit / bld.stepLength,
+			NoData:   noData,
 		}
 		for tanX > bld.geoPixelTan[len(bld.geoPixels)] {
 			bld.geoPixels = append(bld.geoPixels, pix)
@@ -164,19 +293,19 @@ func (i *elevationMapletIter) init(front dataset.IntStep, side dataset.IntStep)
 	i.side2 = (side + 1) % dataset.ElevationMapletSize
 }
 
-// traceEastWest iterates through the ElevationMap by incrementing easting step-by-step while adjusting northing accordingly
-func (bld *geoPixelBuilder) traceEastWest(elevationMap dataset.ElevationMap, eastStepper intStepper, northStepper floatStepper) {
-	totalSteps := dataset.IntStep(maxBlaneDistance / bld.stepLength)
-	elevation0 := bld.prevElevation + 9
+// traceEastWest iterates through the ElevationMap by incrementing easting step-by-step while adjusting northing accordingly.
+// It traces the distances from from to to, and returns to, or the distance where it stopped because there is no tile.
+func (bld *geoPixelBuilder) traceEastWest(elevationMap dataset.ElevationMap, eastStepper intStepper, northStepper floatStepper, from float64, to float64) float64 {
+	first, last := bld.steps(from, to)
+	elevation0 := bld.elevation0
 	prevIter := elevationMapletIter{
 		front: 10000,
 		side:  10000,
 		side2: 10000,
 	}
 	var sIter elevationMapletIter
-	var sq0 = elevationMap.LookupElevationMaplet(eastStepper.start, dataset.IntStep(math.Floor(northStepper.start)))
-	var sq1 = elevationMap.LookupElevationMaplet(eastStepper.start, dataset.IntStep(math.Floor(northStepper.start))+1)
-	for i := dataset.IntStep(1); i < totalSteps; i++ {
+	var sq0, sq1 *dataset.ElevationMaplet
+	for i := first; i < last; i++ {
 		eastStep := eastStepper.step(i)
 		northFloat := northStepper.step(i)
 		northStep := dataset.IntStep(math.Floor(northFloat))
@@ -184,11 +313,11 @@ func (bld *geoPixelBuilder) traceEastWest(elevationMap dataset.ElevationMap, eas
 		sIter.init(eastStep, northStep)
 
 		if atBorder(prevIter.front, sIter.front) {
-			elevationLimit := elevation0 + bld.elevationLimit(i)
-
-			if elevationMap.MaxElevation(eastStep, northStep) < elevationLimit &&
-				elevationMap.MaxElevation(eastStep, northStep+dataset.IntStep(northStepper.stepLen*dataset.ElevationMapletSize)) < elevationLimit {
-				i += dataset.ElevationMapletSize - 1
+			skip := bld.skippableSteps(elevationMap, i, eastStep, eastStepper.stepLen, 1, func(i dataset.IntStep) (dataset.IntStep, dataset.IntStep) {
+				return eastStepper.step(i), dataset.IntStep(math.Floor(northStepper.step(i)))
+			})
+			if skip >= 0 {
+				i += skip
 				eastingIndex := eastStepper.step(i)
 				northFloat = northStepper.step(i)
 				northStep = dataset.IntStep(math.Floor(northFloat))
@@ -197,12 +326,12 @@ func (bld *geoPixelBuilder) traceEastWest(elevationMap dataset.ElevationMap, eas
 			}
 			sq0 = elevationMap.LookupElevationMaplet(eastStep, northStep)
 			if sq0 == nil {
-				break
+				return float64(i) * bld.stepLength
 			}
 			if sIter.side2 == 0 {
 				sq1 = elevationMap.LookupElevationMaplet(eastStep, northStep+1)
 				if sq1 == nil {
-					break
+					return float64(i) * bld.stepLength
 				}
 			} else {
 				sq1 = sq0
@@ -214,7 +343,7 @@ func (bld *geoPixelBuilder) traceEastWest(elevationMap dataset.ElevationMap, eas
 				} else {
 					sq0 = elevationMap.LookupElevationMaplet(eastStep, northStep)
 					if sq0 == nil {
-						break
+						return float64(i) * bld.stepLength
 					}
 				}
 			}
@@ -223,35 +352,36 @@ func (bld *geoPixelBuilder) traceEastWest(elevationMap dataset.ElevationMap, eas
 				if sIter.side2 == 0 {
 					sq1 = elevationMap.LookupElevationMaplet(eastStep, northStep+1)
 					if sq1 == nil {
-						break
+						return float64(i) * bld.stepLength
 					}
 				} else {
 					sq1 = sq0
 				}
 			}
 		}
-		elevation := weightElevation(sq0[sIter.side][sIter.front],
+		elevation, noData := weightElevation(sq0[sIter.side][sIter.front],
 			sq1[sIter.side2][sIter.front],
 			northFloat-float64(northStep))
-		bld.updateState(elevation-elevation0, i)
+		bld.updateState(elevation-elevation0, noData, i)
 
 		prevIter = sIter
 	}
+	return to
 }
 
-// traceNorthSouth iterates through the ElevationMap by incrementing northing step-by-step while adjusting easting accordingly
-func (bld *geoPixelBuilder) traceNorthSouth(elevationMap dataset.ElevationMap, eastStepper floatStepper, northStepper intStepper) {
-	totalSteps := dataset.IntStep(maxBlaneDistance / bld.stepLength)
-	elevation0 := bld.prevElevation + 9
+// traceNorthSouth iterates through the ElevationMap by incrementing northing step-by-step while adjusting easting accordingly.
+// It traces the distances from from to to, and returns to, or the distance where it stopped because there is no tile.
+func (bld *geoPixelBuilder) traceNorthSouth(elevationMap dataset.ElevationMap, eastStepper floatStepper, northStepper intStepper, from float64, to float64) float64 {
+	first, last := bld.steps(from, to)
+	elevation0 := bld.elevation0
 	prevIter := elevationMapletIter{
 		front: 10000,
 		side:  10000,
 		side2: 10000,
 	}
 	var sIter elevationMapletIter
-	var sq0 = elevationMap.LookupElevationMaplet(dataset.IntStep(math.Floor(eastStepper.start)), northStepper.start)
-	var sq1 = elevationMap.LookupElevationMaplet(dataset.IntStep(math.Floor(eastStepper.start))+1, northStepper.start)
-	for i := dataset.IntStep(1); i < totalSteps; i++ {
+	var sq0, sq1 *dataset.ElevationMaplet
+	for i := first; i < last; i++ {
 		northStep := northStepper.step(i)
 		eastFloat := eastStepper.step(i)
 		eastStep := dataset.IntStep(math.Floor(eastFloat))
@@ -259,12 +389,11 @@ func (bld *geoPixelBuilder) traceNorthSouth(elevationMap dataset.ElevationMap, e
 		sIter.init(northStep, eastStep)
 
 		if atBorder(prevIter.front, sIter.front) {
-			//elevationLimit := elevation0 + bld.elevationLimit(i)
-			elevationLimit := elevation0 + bld.elevationLimit(i)
-
-			if elevationMap.MaxElevation(eastStep, northStep) < elevationLimit &&
-				elevationMap.MaxElevation(eastStep+dataset.IntStep(eastStepper.stepLen*dataset.ElevationMapletSize), northStep) < elevationLimit { //?
-				i += dataset.ElevationMapletSize - 1
+			skip := bld.skippableSteps(elevationMap, i, northStep, northStepper.stepLen, 1, func(i dataset.IntStep) (dataset.IntStep, dataset.IntStep) {
+				return dataset.IntStep(math.Floor(eastStepper.step(i))), northStepper.step(i)
+			})
+			if skip >= 0 {
+				i += skip
 				northStep = northStepper.step(i)
 				eastFloat = eastStepper.step(i)
 				eastStep = dataset.IntStep(math.Floor(eastFloat))
@@ -273,12 +402,12 @@ func (bld *geoPixelBuilder) traceNorthSouth(elevationMap dataset.ElevationMap, e
 			}
 			sq0 = elevationMap.LookupElevationMaplet(eastStep, northStep)
 			if sq0 == nil {
-				break
+				return float64(i) * bld.stepLength
 			}
 			if sIter.side2 == 0 {
 				sq1 = elevationMap.LookupElevationMaplet(eastStep+1, northStep)
 				if sq1 == nil {
-					break
+					return float64(i) * bld.stepLength
 				}
 			} else {
 				sq1 = sq0
@@ -290,7 +419,7 @@ func (bld *geoPixelBuilder) traceNorthSouth(elevationMap dataset.ElevationMap, e
 				} else {
 					sq0 = elevationMap.LookupElevationMaplet(eastStep, northStep)
 					if sq0 == nil {
-						break
+						return float64(i) * bld.stepLength
 					}
 				}
 			}
@@ -299,36 +428,207 @@ func (bld *geoPixelBuilder) traceNorthSouth(elevationMap dataset.ElevationMap, e
 				if sIter.side2 == 0 {
 					sq1 = elevationMap.LookupElevationMaplet(eastStep+1, northStep)
 					if sq1 == nil {
-						break
+						return float64(i) * bld.stepLength
 					}
 				} else {
 					sq1 = sq0
 				}
 			}
 		}
-		elevation := weightElevation(sq0[sIter.front][sIter.side],
+		elevation, noData := weightElevation(sq0[sIter.front][sIter.side],
 			sq1[sIter.front][sIter.side2],
 			eastFloat-float64(eastStep))
 
-		bld.updateState(elevation-elevation0, i)
+		bld.updateState(elevation-elevation0, noData, i)
 		prevIter = sIter
 	}
+	return to
+}
+
+// interpolationSteps is the number of steps between each point in the forward direction when interpolating
+const interpolationSteps = 4
+
+// traceInterpolated is like traceEastWest and traceNorthSouth, but takes interpolationSteps steps between the points
+// and interpolates the elevation at the exact position of each step using t.Interpolation
+func (t *Transform) traceInterpolated(bld *geoPixelBuilder, elevationMap dataset.ElevationMap, sin float64, cos float64, from float64, to float64) float64 {
+	minEasting, maxNorthing := elevationMap.Offsets()
+	spacing := elevationMap.Spacing()
+	x0, y0 := (t.Easting-minEasting)/spacing, (maxNorthing-t.Northing)/spacing
+	dx, dy := bld.stepLength*sin/spacing, -bld.stepLength*cos/spacing
+
+	position := func(i dataset.IntStep) (dataset.IntStep, dataset.IntStep) {
+		return dataset.IntStep(math.Floor(x0 + float64(i)*dx)), dataset.IntStep(math.Floor(y0 + float64(i)*dy))
+	}
+
+	// The position in the forward direction is counted in steps, so that it changes by one for each step
+	front := func(i dataset.IntStep) dataset.IntStep {
+		return dataset.IntStep(math.Floor((x0 + float64(i)*dx) * interpolationSteps))
+	}
+	frontStepLen := sign(dx)
+	if math.Abs(dy) > math.Abs(dx) {
+		front = func(i dataset.IntStep) dataset.IntStep {
+			return dataset.IntStep(math.Floor((y0 + float64(i)*dy) * interpolationSteps))
+		}
+		frontStepLen = sign(dy)
+	}
+
+	interpolator := elevationMap.Interpolator(t.Interpolation)
+	first, last := bld.steps(from, to)
+	maplet := dataset.IntStep(-1)
+	for i := first; i < last; i++ {
+		if f := front(i); f/(dataset.ElevationMapletSize*interpolationSteps) != maplet {
+			maplet = f / (dataset.ElevationMapletSize * interpolationSteps)
+			if skip := bld.skippableSteps(elevationMap, i, f, frontStepLen, interpolationSteps, position); skip >= 0 {
+				i += skip
+				continue
+			}
+		}
+
+		elevation, noData, ok := interpolator.Interpolate(x0+float64(i)*dx, y0+float64(i)*dy)
+		if !ok {
+			return float64(i) * bld.stepLength
+		}
+		bld.updateState(elevation-bld.elevation0, noData, i)
+	}
+	return to
+}
+
+// steps returns the first and last (exclusive) step to trace the distances from from to to
+func (bld *geoPixelBuilder) steps(from float64, to float64) (first dataset.IntStep, last dataset.IntStep) {
+	first = dataset.IntStep(math.Ceil(from / bld.stepLength))
+	if first < 1 {
+		first = 1
+	}
+	return first, dataset.IntStep(to / bld.stepLength)
+}
+
+// remainingSteps returns the number of steps from front to the last point in the same block of the given size, when
+// stepping in the direction of stepLen. The front is negative west and north of the grid origin, so the position in
+// the block is a floor modulo.
+func remainingSteps(front dataset.IntStep, stepLen dataset.IntStep, size dataset.IntStep) dataset.IntStep {
+	inBlock := (front%size + size) % size
+	if stepLen < 0 {
+		return inBlock
+	}
+	return size - 1 - inBlock
+}
+
+// start returns the position of the viewpoint in the grid of the elevation map
+func (t *Transform) start(elevationMap dataset.ElevationMap) (eastingStart dataset.IntStep, northingStart dataset.IntStep) {
+	minEasting, maxNorthing := elevationMap.Offsets()
+	spacing := elevationMap.Spacing()
+	eastingStart = dataset.IntStep(math.Round((t.Easting - minEasting) / spacing))
+	northingStart = dataset.IntStep(math.Round((maxNorthing - t.Northing) / spacing))
+	return eastingStart, northingStart
+}
+
+// coarserDataAt returns true if any of the elevation maps after t.elevMaps[i] has a tile at the given distance in the
+// direction given by sin/cos
+func (t *Transform) coarserDataAt(i int, distance float64, sin float64, cos float64) bool {
+	for _, elevationMap := range t.elevMaps[i+1:] {
+		eastingStart, northingStart := t.start(elevationMap)
+		spacing := elevationMap.Spacing()
+		e := eastingStart + dataset.IntStep(math.Round(distance*sin/spacing))
+		n := northingStart - dataset.IntStep(math.Round(distance*cos/spacing))
+		if elevationMap.LookupElevationMaplet(e, n) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// trace iterates through the elevation map in the direction given by sin/cos, from distance from to distance to.
+// It returns the distance where it stopped.
+func (t *Transform) trace(bld *geoPixelBuilder, elevationMap dataset.ElevationMap, sin float64, cos float64, from float64, to float64) float64 {
+	eastingStart, northingStart := t.start(elevationMap)
+	spacing := elevationMap.Spacing()
+
+	if t.Interpolation != 0 {
+		bld.stepLength = spacing / math.Max(math.Abs(sin), math.Abs(cos)) / interpolationSteps
+		return t.traceInterpolated(bld, elevationMap, sin, cos, from, to)
+	}
+
+	if math.Abs(sin) > math.Abs(cos) {
+		bld.stepLength = spacing / math.Abs(sin)
+		return bld.traceEastWest(elevationMap,
+			intStepper{
+				start:   eastingStart,
+				stepLen: sign(sin),
+			},
+			floatStepper{
+				start:   float64(northingStart),
+				stepLen: -cos / math.Abs(sin),
+			}, from, to)
+	}
+
+	bld.stepLength = spacing / math.Abs(cos)
+	return bld.traceNorthSouth(elevationMap,
+		floatStepper{
+			start:   float64(eastingStart),
+			stepLen: sin / math.Abs(cos),
+		},
+		intStepper{
+			start:   northingStart,
+			stepLen: -sign(cos),
+		}, from, to)
+}
+
+// levelDistance returns the distance where the angular footprint of a cell with the given spacing is smaller than
+// a geo pixel, which is a sub-pixel of the image
+func (t *Transform) levelDistance(spacing float64) float64 {
+	if t.Projection == Cylindrical {
+		// The geo pixels are smallest at the horizon, where the distant terrain is
+		bottom := t.BottomAngle()
+		return spacing * float64(t.GeoPixelLen) / (math.Tan(bottom+t.heightAngle()) - math.Tan(bottom))
+	}
+	return spacing * float64(t.GeoPixelLen) / t.heightAngle()
+}
+
+// traceLevels is like trace for t.elevMaps[i], but switches to a coarser level of the elevation map at the distance
+// where the cells of the current level are smaller than a geo pixel
+func (t *Transform) traceLevels(bld *geoPixelBuilder, i int, sin float64, cos float64, from float64, to float64) float64 {
+	levels := t.levels[i]
+	distance := from
+	for k, level := range levels {
+		levelTo := to
+		if k < len(levels)-1 {
+			levelTo = math.Min(to, t.levelDistance(level.Spacing()))
+		}
+		if distance >= levelTo {
+			continue
+		}
+
+		distance = t.trace(bld, level, sin, cos, distance, levelTo)
+		if distance < levelTo {
+			break
+		}
+	}
+	return distance
 }
 
 //TraceDirection iterates through the ElevationMap to build a column of GeoPixel that can be used to render an image.
 //The iteration starts at [t.Northing, t.Easting] and the direction is given by rad.
+//The finest elevation data available is used at each distance. Data with one spacing is used for spacingSteps steps
+//if there is coarser data to continue with, or until there is no more data with the spacing. Distant parts are traced
+//in downsampled levels of the data, see traceLevels.
 func (t *Transform) TraceDirection(rad float64, pixels []GeoPixel) []GeoPixel {
-	t.init()
-	northing0 := math.Round(t.Northing/dataset.Unit) * dataset.Unit
-	easting0 := math.Round(t.Easting/dataset.Unit) * dataset.Unit
+	t.Init()
 
-	minEasting, maxNorthing := t.ElevMap.Offsets()
-	var eastingStart = dataset.IntStep(easting0-minEasting) / dataset.Unit
-	var northingStart = dataset.IntStep(maxNorthing-northing0) / dataset.Unit
+	// Viewpoints without elevation data are at sea level
+	elevation := math.NaN()
+	for _, elevationMap := range t.elevMaps {
+		if elevation = elevationMap.Elevation(t.start(elevationMap)); !math.IsNaN(elevation) {
+			break
+		}
+	}
+	if math.IsNaN(elevation) {
+		elevation = 0
+	}
 
 	bld := geoPixelBuilder{
+		elevation0:    elevation + 9,
 		geoPixels:     pixels,
-		prevElevation: t.ElevMap.Elevation(eastingStart, northingStart),
+		prevElevation: elevation,
 		geoPixelLen:   t.GeoPixelLen,
 		geoPixelTan:   t.geoPixelTan,
 	}
@@ -336,28 +636,20 @@ func (t *Transform) TraceDirection(rad float64, pixels []GeoPixel) []GeoPixel {
 	sin := math.Sin(rad) // east
 	cos := math.Cos(rad) // north
 
-	if math.Abs(sin) > math.Abs(cos) {
-		bld.stepLength = dataset.Unit / math.Abs(sin)
-		bld.traceEastWest(t.ElevMap,
-			intStepper{
-				start:   eastingStart,
-				stepLen: sign(sin),
-			},
-			floatStepper{
-				start:   float64(northingStart),
-				stepLen: -cos / math.Abs(sin),
-			})
-	} else {
-		bld.stepLength = dataset.Unit / math.Abs(cos)
-		bld.traceNorthSouth(t.ElevMap,
-			floatStepper{
-				start:   float64(eastingStart),
-				stepLen: sin / math.Abs(cos),
-			},
-			intStepper{
-				start:   northingStart,
-				stepLen: -sign(cos),
-			})
+	distance := 0.0
+	for i, elevationMap := range t.elevMaps {
+		to := maxBlaneDistance
+		if i < len(t.elevMaps)-1 {
+			to = math.Min(to, elevationMap.Spacing()*spacingSteps)
+		}
+		if distance >= to {
+			continue
+		}
+
+		distance = t.traceLevels(&bld, i, sin, cos, distance, to)
+		if distance == to && !t.coarserDataAt(i, distance, sin, cos) {
+			distance = t.traceLevels(&bld, i, sin, cos, distance, maxBlaneDistance)
+		}
 	}
 
 	return bld.geoPixels
@@ -0,0 +1,271 @@
+package transform
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/larschri/blaneblikk/dataset"
+	"github.com/larschri/blaneblikk/internal/terraintest"
+)
+
+func TestMain(m *testing.M) {
+	terraintest.Main(m)
+}
+
+// benchmarkView traces the columns of a view with the given direction and width in radians, like the renderer
+func benchmarkView(b *testing.B, start float64, width float64, interpolation dataset.Interpolation) {
+	elevationMap := terraintest.Load(b)
+	const columns = 800
+	t := Transform{
+		Easting:       terraintest.Easting,
+		Northing:      terraintest.Northing,
+		ElevMap:       elevationMap,
+		GeoPixelLen:   int(TotalHeightAngle*columns/width) * 3,
+		Interpolation: interpolation,
+	}
+
+	pixels := make([]GeoPixel, 0, t.GeoPixelLen)
+	trace := func() {
+		for i := 0; i < columns; i++ {
+			t.TraceDirection(start+float64(i)*width/columns, pixels[:0])
+		}
+	}
+
+	// The tiles are mapped before the timer starts
+	trace()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		trace()
+	}
+}
+
+func BenchmarkTraceDirection(b *testing.B) {
+	b.Run("default", func(b *testing.B) { benchmarkView(b, 0, math.Pi*2/64, 0) })
+	b.Run("sea", func(b *testing.B) { benchmarkView(b, math.Pi/4, math.Pi*2/64, 0) })
+	b.Run("wide", func(b *testing.B) { benchmarkView(b, 0, math.Pi/2, 0) })
+	b.Run("panorama", func(b *testing.B) { benchmarkView(b, 0, math.Pi*2, 0) })
+	b.Run("bilinear", func(b *testing.B) { benchmarkView(b, 0, math.Pi*2/64, dataset.Bilinear) })
+	b.Run("bicubic", func(b *testing.B) { benchmarkView(b, 0, math.Pi*2/64, dataset.Bicubic) })
+}
+
+func TestTraceInterpolated(t *testing.T) {
+	elevationMap := terraintest.Load(t)
+	for _, direction := range []float64{0, 0.3, math.Pi / 4, 2, math.Pi, 4.5} {
+		tr := Transform{
+			Easting:     terraintest.Easting,
+			Northing:    terraintest.Northing,
+			ElevMap:     elevationMap,
+			GeoPixelLen: 3000,
+		}
+		want := tr.TraceDirection(direction, make([]GeoPixel, 0, tr.GeoPixelLen))
+
+		// The same terrain is seen, so the distances of the pixels are nearly the same
+		for _, interpolation := range []dataset.Interpolation{dataset.Bilinear, dataset.Bicubic} {
+			tr.Interpolation = interpolation
+			got := tr.TraceDirection(direction, make([]GeoPixel, 0, tr.GeoPixelLen))
+			if len(got) != len(want) {
+				t.Fatalf("interpolation %d in direction %v gives %d pixels, want %d", interpolation, direction, len(got), len(want))
+			}
+			differ := 0
+			for i := range got {
+				if math.Abs(got[i].Distance-want[i].Distance) > math.Max(100, want[i].Distance/20) {
+					differ++
+				}
+			}
+			if differ > len(got)/50 {
+				t.Errorf("interpolation %d in direction %v: %d of %d pixels differ", interpolation, direction, differ, len(got))
+			}
+		}
+	}
+}
+
+func TestTilt(t *testing.T) {
+	elevationMap := terraintest.Load(t)
+	const geoPixelLen, shift = 3000, 300
+	for _, direction := range []float64{0.3, 2, 4.5} {
+		tr := Transform{
+			Easting:     terraintest.Easting,
+			Northing:    terraintest.Northing,
+			ElevMap:     elevationMap,
+			GeoPixelLen: geoPixelLen,
+		}
+		want := tr.TraceDirection(direction, nil)
+
+		// Tilting the view up by a number of geo pixels moves the terrain down by the same number
+		tilted := Transform{
+			Easting:     terraintest.Easting,
+			Northing:    terraintest.Northing,
+			ElevMap:     elevationMap,
+			GeoPixelLen: geoPixelLen,
+			Tilt:        shift * TotalHeightAngle / geoPixelLen,
+		}
+		got := tilted.TraceDirection(direction, nil)
+		if math.Abs(tilted.BottomAngle()-tr.BottomAngle()-tilted.Tilt) > 1e-12 {
+			t.Errorf("bottom angle is %v, want %v", tilted.BottomAngle(), tr.BottomAngle()+tilted.Tilt)
+		}
+
+		differ := 0
+		for i := 0; i+shift < len(want) && i < len(got); i++ {
+			if got[i] != want[i+shift] {
+				differ++
+			}
+		}
+		if len(got) < len(want)-shift || differ > len(got)/100 {
+			t.Errorf("direction %v: %d of %d pixels differ", direction, differ, len(got))
+		}
+	}
+}
+
+func TestCylindrical(t *testing.T) {
+	elevationMap := terraintest.Load(t)
+	const geoPixelLen = 3000
+	equirectangular := Transform{
+		Easting:     terraintest.Easting,
+		Northing:    terraintest.Northing,
+		ElevMap:     elevationMap,
+		GeoPixelLen: geoPixelLen,
+	}
+	cylindrical := equirectangular
+	cylindrical.Projection = Cylindrical
+	equirectangular.Init()
+	cylindrical.Init()
+
+	// The views start at the same angle, and the tangents of the cylindrical view are evenly spaced up to the last one,
+	// which is infinity
+	if equirectangular.geoPixelTan[0] != cylindrical.geoPixelTan[0] {
+		t.Errorf("bottom is %v, want %v", cylindrical.geoPixelTan[0], equirectangular.geoPixelTan[0])
+	}
+	step := cylindrical.geoPixelTan[1] - cylindrical.geoPixelTan[0]
+	for i := 1; i < geoPixelLen-1; i++ {
+		if d := cylindrical.geoPixelTan[i] - cylindrical.geoPixelTan[i-1]; math.Abs(d-step) > 1e-12 {
+			t.Fatalf("step %d is %v, want %v", i, d, step)
+		}
+	}
+
+	// The view is close to the horizon, where the projections are nearly the same
+	for _, tr := range []*Transform{&equirectangular, &cylindrical} {
+		horizon := 0
+		for horizon < geoPixelLen && tr.geoPixelTan[horizon] < 0 {
+			horizon++
+		}
+		if want := int(math.Round(-bottomHeightAngle / totalHeightAngle * geoPixelLen)); horizon < want-1 || horizon > want+1 {
+			t.Errorf("projection %d has the horizon at %d, want %d", tr.Projection, horizon, want)
+		}
+	}
+
+	if len(cylindrical.TraceDirection(1, nil)) == 0 {
+		t.Errorf("no terrain in the cylindrical view")
+	}
+}
+
+func TestRemainingSteps(t *testing.T) {
+	for _, tc := range []struct {
+		front, stepLen, want dataset.IntStep
+	}{
+		{0, 1, 199},
+		{0, -1, 0},
+		{250, 1, 149},
+		{250, -1, 50},
+		{-1, 1, 0},
+		{-1, -1, 199},
+		{-250, 1, 49},
+		{-250, -1, 150},
+	} {
+		if got := remainingSteps(tc.front, tc.stepLen, 200); got != tc.want {
+			t.Errorf("remainingSteps(%d, %d, 200) = %d, want %d", tc.front, tc.stepLen, got, tc.want)
+		}
+	}
+}
+
+func TestTraceOutsideGrid(t *testing.T) {
+	// Viewpoints just north of the grid origin have no terrain, but are traced without panics
+	tr := Transform{
+		Easting:     880_000,
+		Northing:    10_020_000,
+		ElevMap:     terraintest.Load(t),
+		GeoPixelLen: 300,
+	}
+	for _, direction := range []float64{0, 1, 2.5, 4, 5.5} {
+		if got := tr.TraceDirection(direction, nil); len(got) != 0 {
+			t.Errorf("direction %v has %d geo pixels", direction, len(got))
+		}
+	}
+}
+
+// flatReader produces flat tiles at sea level, named by their position in UTM zone 32, and counts the tiles it reads
+type flatReader struct {
+	reads int64
+}
+
+func (r *flatReader) Locate(fname string) (dataset.TilePosition, error) {
+	tile := dataset.TilePosition{Zone: 32, Spacing: dataset.Unit}
+	_, err := fmt.Sscanf(filepath.Base(fname), "%f %f", &tile.MinEasting, &tile.MaxNorthing)
+	return tile, err
+}
+
+func (r *flatReader) ReadFile(fname string) ([][]float32, dataset.TilePosition, error) {
+	atomic.AddInt64(&r.reads, 1)
+	tile, err := r.Locate(fname)
+	buffer := make([][]float32, terraintest.TileSize/dataset.Unit+1)
+	for i := range buffer {
+		buffer[i] = make([]float32, terraintest.TileSize/dataset.Unit+1)
+	}
+	return buffer, tile, err
+}
+
+func TestTraceConvertsTilesOnTheWay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 8x8 tiles with the viewpoint in the middle of the south western tile
+	const minEasting, minNorthing = 300_000, 6_500_000
+	var fNames []string
+	for i := 0; i < 8; i++ {
+		for j := 0; j < 8; j++ {
+			fName := filepath.Join(dir, fmt.Sprintf("%d %d", minEasting+i*terraintest.TileSize, minNorthing+(j+1)*terraintest.TileSize))
+			if err := ioutil.WriteFile(fName, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			fNames = append(fNames, fName)
+		}
+	}
+
+	// Only the tiles along the ray are converted. It is 200 km long, so it crosses 5 tiles to the north.
+	for _, tc := range []struct {
+		direction float64
+		want      int64
+	}{
+		{0, 5},
+		{math.Pi, 1},
+	} {
+		// The mmap files are in a new directory for each ray, so that they are converted again
+		mmapDir, err := ioutil.TempDir(dir, "mmap")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := flatReader{}
+		elevationMap, _, err := dataset.LoadFiles(&r, mmapDir, fNames, dataset.LoadOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := Transform{
+			Easting:     minEasting + terraintest.TileSize/2,
+			Northing:    minNorthing + terraintest.TileSize/2,
+			ElevMap:     elevationMap,
+			GeoPixelLen: 900,
+		}
+		tr.TraceDirection(tc.direction, nil)
+		if r.reads != tc.want {
+			t.Errorf("direction %v converts %d tiles, want %d", tc.direction, r.reads, tc.want)
+		}
+		elevationMap.Close()
+	}
+}